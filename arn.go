@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Known AWS partitions and DNS suffixes of their endpoints
+var awsPartitions = map[string]string{
+	"aws":        "amazonaws.com",
+	"aws-cn":     "amazonaws.com.cn",
+	"aws-us-gov": "amazonaws.com",
+	"aws-iso":    "c2s.ic.gov",
+	"aws-iso-b":  "sc2s.sgov.gov",
+	"aws-iso-e":  "cloud.adc-e.uk",
+	"aws-iso-f":  "csp.hci.ic.gov",
+}
+
+// DNS suffixes of the dual-stack (IPv4 and IPv6) endpoints of partitions that have them
+var awsDualStackPartitions = map[string]string{
+	"aws":        "api.aws",
+	"aws-cn":     "api.amazonwebservices.com.cn",
+	"aws-us-gov": "api.aws",
+}
+
+// Region name prefixes of partitions other than aws
+var awsPartitionRegionPrefixes = []struct {
+	prefix    string
+	partition string
+}{
+	{"cn-", "aws-cn"},
+	{"us-gov-", "aws-us-gov"},
+	{"us-iso-", "aws-iso"},
+	{"us-isob-", "aws-iso-b"},
+	{"eu-isoe-", "aws-iso-e"},
+	{"us-isof-", "aws-iso-f"},
+}
+
+// Returns partition of given AWS region
+func regionPartition(region string) string {
+	for _, p := range awsPartitionRegionPrefixes {
+		if strings.HasPrefix(region, p.prefix) {
+			return p.partition
+		}
+	}
+	return "aws"
+}
+
+var awsAccountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+// EKS cluster names: 1-100 alphanumerics, hyphens and underscores, starting with an alphanumeric
+var eksClusterNamePattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z_-]{0,99}$`)
+
+// Validates that roleARN is a structurally valid IAM role ARN, naming the offending component otherwise
+func validateRoleARN(roleARN string) error {
+	parsed, err := arn.Parse(roleARN)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid ARN, expected arn:<partition>:iam::<account id>:role/<name>: %w", roleARN, err)
+	}
+	if _, ok := awsPartitions[parsed.Partition]; !ok {
+		return fmt.Errorf("%q has unknown partition %q", roleARN, parsed.Partition)
+	}
+	if parsed.Service != "iam" {
+		return fmt.Errorf("%q has service %q, expected iam", roleARN, parsed.Service)
+	}
+	if parsed.Region != "" {
+		return fmt.Errorf("%q has region %q, IAM role ARNs have an empty region", roleARN, parsed.Region)
+	}
+	if !awsAccountIDPattern.MatchString(parsed.AccountID) {
+		return fmt.Errorf("%q has account id %q, expected 12 digits", roleARN, parsed.AccountID)
+	}
+	if !strings.HasPrefix(parsed.Resource, "role/") || len(parsed.Resource) == len("role/") {
+		return fmt.Errorf("%q has resource %q, expected role/<name>", roleARN, parsed.Resource)
+	}
+	return nil
+}
+
+// Returns EKS cluster name given either as a plain name or a cluster ARN
+// (arn:<partition>:eks:<region>:<account id>:cluster/<name>), validating it against EKS naming rules
+func parseClusterName(cluster string) (string, error) {
+	name := cluster
+	if arn.IsARN(cluster) {
+		parsed, err := arn.Parse(cluster)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid ARN: %w", cluster, err)
+		}
+		if parsed.Service != "eks" || !strings.HasPrefix(parsed.Resource, "cluster/") {
+			return "", fmt.Errorf("%q is not an EKS cluster ARN, expected arn:<partition>:eks:<region>:<account id>:cluster/<name>", cluster)
+		}
+		name = strings.TrimPrefix(parsed.Resource, "cluster/")
+	}
+	if !eksClusterNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%q is not a valid EKS cluster name, expected 1-100 letters, digits, hyphens and underscores starting with a letter or digit", name)
+	}
+	return name, nil
+}
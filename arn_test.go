@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRoleARN(t *testing.T) {
+	tests := []struct {
+		arn     string
+		wantErr string // Substring of the expected error, empty when valid
+	}{
+		{"arn:aws:iam::123456789012:role/argocd", ""},
+		{"arn:aws:iam::123456789012:role/path/to/argocd", ""},
+		{"arn:aws-cn:iam::123456789012:role/argocd", ""},
+		{"arn:aws-us-gov:iam::123456789012:role/argocd", ""},
+		{"argocd", "not a valid ARN"},
+		{"arn:aws:iam::123456789012", "not a valid ARN"},
+		{"arn:aws-moon:iam::123456789012:role/argocd", "unknown partition"},
+		{"arn:aws:sts::123456789012:role/argocd", "service \"sts\""},
+		{"arn:aws:iam:us-east-1:123456789012:role/argocd", "region \"us-east-1\""},
+		{"arn:aws:iam::12345:role/argocd", "expected 12 digits"},
+		{"arn:aws:iam::12345678901a:role/argocd", "expected 12 digits"},
+		{"arn:aws:iam::123456789012:user/argocd", "expected role/<name>"},
+		{"arn:aws:iam::123456789012:role/", "expected role/<name>"},
+	}
+	for _, tt := range tests {
+		err := validateRoleARN(tt.arn)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("validateRoleARN(%q) error = %v", tt.arn, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("validateRoleARN(%q) error = %v, want error containing %q", tt.arn, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseClusterName(t *testing.T) {
+	tests := []struct {
+		cluster string
+		want    string
+		wantErr bool
+	}{
+		{"production", "production", false},
+		{"prod-eu_1", "prod-eu_1", false},
+		{"arn:aws:eks:eu-west-1:123456789012:cluster/production", "production", false},
+		{"arn:aws-cn:eks:cn-north-1:123456789012:cluster/prod-cn", "prod-cn", false},
+		{"", "", true},
+		{"arn:aws:iam::123456789012:role/production", "", true},
+		{"arn:aws:eks:eu-west-1:123456789012:nodegroup/production/ng/1", "", true},
+		{"arn:aws:eks:eu-west-1:123456789012:cluster/-production", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseClusterName(tt.cluster)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseClusterName(%q) = %q, %v, want %q, error %v", tt.cluster, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseClusterNameCharacters(t *testing.T) {
+	tests := []struct {
+		cluster string
+		valid   bool
+	}{
+		{"a", true},
+		{"0cluster", true},
+		{"Cluster_With-Mixed_Case", true},
+		{strings.Repeat("a", 100), true},
+		{strings.Repeat("a", 101), false},
+		{"-cluster", false},
+		{"_cluster", false},
+		{"my.cluster", false},
+		{"my cluster", false},
+		{"my/cluster", false},
+		{"klüster", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseClusterName(tt.cluster); (err == nil) != tt.valid {
+			t.Errorf("parseClusterName(%q) error = %v, want valid %v", tt.cluster, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Audit log entry of an issued credential. It must never contain the credential itself.
+type auditRecord struct {
+	Time             time.Time `json:"time"`
+	RoleARN          string    `json:"roleArn,omitempty"`
+	Cluster          string    `json:"cluster"`
+	SessionName      string    `json:"sessionName"`
+	CredentialSource string    `json:"credentialSource"`
+	OutputFormat     string    `json:"outputFormat"`
+	Expiration       time.Time `json:"expiration"`
+}
+
+// Returns audit log entry of a credential issued now for given session and expiring at expiration
+func newAuditRecord(cfg *Config, sessionIdentifier string, expiration time.Time) auditRecord {
+	return auditRecord{
+		Time:             time.Now().UTC(),
+		RoleARN:          cfg.AWSRoleARN,
+		Cluster:          cfg.EKSClusterName,
+		SessionName:      sessionIdentifier,
+		CredentialSource: cfg.CredentialSource,
+		OutputFormat:     cfg.OutputFormat,
+		Expiration:       expiration.UTC(),
+	}
+}
+
+// Appends record as a single JSON line to the audit log at path. The file is opened in
+// append mode and locked while writing, so concurrent processes never interleave entries.
+func appendAuditRecord(path string, record auditRecord) error {
+	enc, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %w", err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	if _, err := f.Write(append(enc, '\n')); err != nil {
+		return fmt.Errorf("f.Write: %w", err)
+	}
+	return nil
+}
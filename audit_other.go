@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Advisory locks aren't used on this platform, append mode alone keeps writes whole
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) {}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogRecordsIssuedCredentials(t *testing.T) {
+	captureLogs(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for _, format := range []string{outputFormatExecCredential, outputFormatJSON} {
+		cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-mock", "-audit-log", path, "-output-format", format)
+		if _, err := issueCredential(context.Background(), cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1); err != nil {
+			t.Fatalf("issueCredential(%s) error = %v", format, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var formats []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, mockAccessKeyID) || strings.Contains(line, tokenV1Prefix) {
+			t.Errorf("audit record %s contains the credential", line)
+		}
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid audit record %q: %v", line, err)
+		}
+		if record.RoleARN != testRoleARN || record.Cluster != "test" || record.SessionName != "session" ||
+			record.CredentialSource != credentialSourceWebIdentity || record.Expiration.IsZero() {
+			t.Errorf("audit record = %+v", record)
+		}
+		formats = append(formats, record.OutputFormat)
+	}
+	if strings.Join(formats, ",") != outputFormatExecCredential+","+outputFormatJSON {
+		t.Errorf("audit record formats = %q, want one record per issued credential", formats)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("audit log mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestAuditLogFailureWithholdsCredential(t *testing.T) {
+	captureLogs(t)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-mock",
+		"-audit-log", filepath.Join(t.TempDir(), "missing", "audit.log"))
+	if output, err := issueCredential(context.Background(), cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1); err == nil || output != "" {
+		t.Errorf("issueCredential() = %q, %v, want no credential when the audit log can't be written", output, err)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Obtains temporary AWS credentials for the configured role using GCP identity
+// token, and presigns EKS authentication requests with them
+type Authenticator struct {
+	cfg                *Config
+	policy             retryPolicy
+	metadataPolicy     retryPolicy
+	metadataHTTPClient *http.Client
+	awsHTTPClient      *awshttp.BuildableClient
+	tokens             identityTokenCache
+	tokenRetriever     stscreds.IdentityTokenRetriever // Used instead of GCP metadata, set by -token-file or -token-stdin
+	credentialSource   func(sessionIdentifier string, token customIdentityTokenRetriever) CredentialSource
+	newSTSClient       func(cfg aws.Config, optFns ...func(*sts.Options)) stsAPI
+
+	awsConfigOnce sync.Once
+	awsConfig     aws.Config
+	awsConfigErr  error
+}
+
+// STS operations used by Authenticator
+type stsAPI interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+	AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error)
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+	PresignGetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// Implements [stsAPI] with the SDK STS client
+type stsClient struct {
+	*sts.Client
+}
+
+func newSTSClient(cfg aws.Config, optFns ...func(*sts.Options)) stsAPI {
+	return stsClient{sts.NewFromConfig(cfg, optFns...)}
+}
+
+func (c stsClient) PresignGetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return sts.NewPresignClient(c.Client).PresignGetCallerIdentity(ctx, params, optFns...)
+}
+
+// Option of [NewAuthenticator]
+type AuthenticatorOption func(*Authenticator)
+
+// Makes Authenticator use given STS client for all STS calls, e.g. a fake in unit tests,
+// instead of SDK clients built from the loaded AWS config
+func WithSTSClient(client stsAPI) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.newSTSClient = func(aws.Config, ...func(*sts.Options)) stsAPI {
+			return client
+		}
+	}
+}
+
+// Creates Authenticator for given configuration
+func NewAuthenticator(cfg *Config, opts ...AuthenticatorOption) *Authenticator {
+	if cfg.AWSEndpoint != "" {
+		logger.Info("Using custom AWS STS endpoint", "endpoint", cfg.AWSEndpoint)
+	}
+	if effective := effectiveTokenExpiration(cfg.TokenExpiration); effective != cfg.TokenExpiration {
+		logger.Warn("Requested token expiration exceeds the lifetime of presigned STS URLs, clamping it",
+			"requested", cfg.TokenExpiration.String(), "effective", effective.String())
+	}
+	a := &Authenticator{
+		cfg:                cfg,
+		policy:             newRetryPolicy(cfg),
+		metadataPolicy:     newMetadataRetryPolicy(cfg),
+		metadataHTTPClient: newMetadataHTTPClient(cfg),
+		awsHTTPClient:      newAWSHTTPClient(cfg),
+	}
+	a.credentialSource = a.newWebIdentityCredentialSource
+	if cfg.CredentialSource == credentialSourceAmbient {
+		a.credentialSource = a.newAmbientCredentialSource
+	} else if profile, ok := credentialSourceProfile(cfg.CredentialSource); ok {
+		a.credentialSource = a.newProfileCredentialSource(profile)
+	}
+	a.newSTSClient = newSTSClient
+	if cfg.TokenFile != "" {
+		a.tokenRetriever = FileTokenRetriever{Path: cfg.TokenFile}
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Returns AWS config used for STS calls. The config is loaded once, as loading probes
+// environment, shared config files and possibly IMDS, and reused by all STS clients.
+func (a *Authenticator) loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	a.awsConfigOnce.Do(func() {
+		// Cancellation of the first caller must not fail all later ones
+		a.awsConfig, a.awsConfigErr = a.loadAWSConfigFor(context.WithoutCancel(ctx))
+	})
+	return a.awsConfig.Copy(), a.awsConfigErr
+}
+
+// Loads AWS config for STS calls with given additional options. Requests carry the
+// -app-id and the program version in their User-Agent for CloudTrail attribution.
+func (a *Authenticator) loadAWSConfigFor(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	optFns = append([]func(*config.LoadOptions) error{
+		config.WithRegion(a.cfg.STSRegion),
+		config.WithRetryer(a.policy.awsRetryer),
+		config.WithHTTPClient(a.awsHTTPClient),
+		config.WithAppID(a.cfg.AppID),
+		config.WithAPIOptions([]func(*middleware.Stack) error{
+			addCallTiming,
+			awsmiddleware.AddUserAgentKeyValue(programName, buildVersion()),
+		}),
+	}, optFns...)
+	if a.cfg.SigningRegion != "" {
+		optFns = append(optFns, config.WithAPIOptions([]func(*middleware.Stack) error{withSigningRegion(a.cfg.SigningRegion)}))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err == nil && a.cfg.AWSEndpoint != "" {
+		awsCfg.BaseEndpoint = aws.String(a.cfg.AWSEndpoint)
+	}
+	return awsCfg, err
+}
+
+// Returns AWS config using given static credentials
+func (a *Authenticator) loadAWSConfigWithCredentials(ctx context.Context, creds aws.Credentials) (aws.Config, error) {
+	awsCfg, err := a.loadAWSConfig(ctx)
+	if err != nil {
+		return awsCfg, err
+	}
+	awsCfg.Credentials = aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: creds})
+	return awsCfg, nil
+}
+
+// Returns AWS session identifier set by -session-name, or creates one from GCP metadata.
+// Without a GCP identity, the local hostname is used instead.
+func (a *Authenticator) GetSessionIdentifier(ctx context.Context) (string, error) {
+	if a.cfg.SessionName != "" {
+		if len(a.cfg.SessionName) < a.cfg.SessionNameMinLen {
+			logger.Warn("Session name is short and may be too generic for CloudTrail auditing",
+				"sessionName", a.cfg.SessionName, "minLength", a.cfg.SessionNameMinLen)
+		}
+		return a.cfg.SessionName, nil
+	}
+	if !a.cfg.usesWebIdentity() {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("os.Hostname: %w", err)
+		}
+		return shortenSessionIdentifier(sanitizeSessionIdentifier(hostname)), nil
+	}
+	var sessionIdentifier string
+	err := a.metadataPolicy.do(ctx, "gcp.metadata", func(ctx context.Context) (err error) {
+		sessionIdentifier, err = createSessionIdentifier(ctx, a.metadataHTTPClient, a.cfg.SessionNameFormat, a.cfg.SessionNameHash)
+		return err
+	})
+	return sessionIdentifier, markMetadataUnavailable(err)
+}
+
+// Retrieves GCP identity token from metadata server, reusing a previously retrieved
+// token for the same audience while it's valid, or reads it from -token-file or -token-stdin
+func (a *Authenticator) GetIdentityToken(ctx context.Context) (customIdentityTokenRetriever, error) {
+	if a.tokenRetriever != nil {
+		return a.retrieveToken()
+	}
+	key := identityTokenKey(a.cfg.Audience, a.cfg.GCPTokenFormat)
+	token, ok := a.tokens.get(key)
+	observeTokenCacheLookup(ok)
+	logger.Debug("Looked up cached GCP identity token", "hit", ok)
+	if ok {
+		return token, nil
+	}
+	token, err := gcpRetrieveGCEVMTokenWithRetry(ctx, a.metadataHTTPClient, a.cfg.Audience, a.cfg.GCPTokenFormat, a.metadataPolicy)
+	if err != nil {
+		return token, a.authError(ErrTokenRetrieval, a.cfg.STSRegion, markMetadataUnavailable(err))
+	}
+	if err := a.checkIdentityTokenClaims(token); err != nil {
+		return customIdentityTokenRetriever{}, a.authError(ErrTokenRetrieval, a.cfg.STSRegion, err)
+	}
+	a.tokens.put(key, token)
+	return token, nil
+}
+
+// Retrieves identity token from the configured token retriever. The token isn't cached,
+// as a token file is the source of truth and may be rotated at any time.
+func (a *Authenticator) retrieveToken() (customIdentityTokenRetriever, error) {
+	b, err := a.tokenRetriever.GetIdentityToken()
+	if err != nil {
+		return customIdentityTokenRetriever{}, a.authError(ErrTokenRetrieval, a.cfg.STSRegion, err)
+	}
+	token := customIdentityTokenRetriever{token: b}
+	if err := a.checkIdentityTokenClaims(token); err != nil {
+		return customIdentityTokenRetriever{}, a.authError(ErrTokenRetrieval, a.cfg.STSRegion, err)
+	}
+	return token, nil
+}
+
+// Logs claims of GCP identity token and, with -verify-audience, checks that its
+// audience matches the configured one, failing before STS rejects the token
+func (a *Authenticator) checkIdentityTokenClaims(token customIdentityTokenRetriever) error {
+	claims, err := decodeJWTClaims(token.token)
+	if err != nil {
+		if a.cfg.VerifyAudience {
+			return fmt.Errorf("couldn't decode GCP identity token claims: %w", err)
+		}
+		logger.Debug("Couldn't decode GCP identity token claims", "error", err)
+		return nil
+	}
+	logger.Debug("Retrieved GCP identity token", "aud", claims.Audience, "sub", claims.Subject, "email", claims.Email)
+	if a.cfg.VerifyAudience && claims.Audience != a.cfg.Audience {
+		return fmt.Errorf("GCP identity token has audience %q, expected %q", claims.Audience, a.cfg.Audience)
+	}
+	return nil
+}
+
+// Assumes the configured AWS role with GCP identity token, or with -credential-source
+// retrieves credentials from the selected source. When STS reports the token as expired,
+// a new token is fetched and the call is retried once.
+func (a *Authenticator) GetCredentials(ctx context.Context, sessionIdentifier string, token customIdentityTokenRetriever) (aws.Credentials, error) {
+	awsCredentials, err := a.retrieveCredentials(ctx, a.credentialSource(sessionIdentifier, token))
+	if err != nil && a.cfg.RetryExpiredToken && a.cfg.usesWebIdentity() && isExpiredTokenError(err) {
+		// The GCP token may expire between fetching it and STS validating it on slow networks
+		logger.Warn("GCP identity token expired before STS accepted it, retrying with a new token", "error", err)
+		a.tokens.invalidate(identityTokenKey(a.cfg.Audience, a.cfg.GCPTokenFormat))
+		token, err = a.GetIdentityToken(ctx)
+		if err != nil {
+			return aws.Credentials{}, fmt.Errorf("failed to get JWT token from GCP metadata: %w", err)
+		}
+		awsCredentials, err = a.retrieveCredentials(ctx, a.credentialSource(sessionIdentifier, token))
+	}
+	if err != nil {
+		return awsCredentials, a.authError(ErrAssumeRole, a.cfg.STSRegion, err)
+	}
+	return awsCredentials, nil
+}
+
+// Retrieves temporary credentials from given credential source
+func (a *Authenticator) retrieveCredentials(ctx context.Context, source CredentialSource) (aws.Credentials, error) {
+	awsCfg, err := source.Credentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	var creds aws.Credentials
+	err = withSTSTimeout(ctx, a.cfg.STSTimeout, func(ctx context.Context) (err error) {
+		creds, err = awsCfg.Credentials.Retrieve(ctx)
+		return err
+	})
+	if isSessionDurationError(err) {
+		return creds, fmt.Errorf("-assume-role-duration=%s exceeds the maximum session duration of role %s: %w", a.cfg.SessionDuration, a.cfg.AWSRoleARN, err)
+	}
+	return creds, err
+}
+
+// Calls STS GetCallerIdentity with given credentials
+func (a *Authenticator) GetCallerIdentity(ctx context.Context, creds aws.Credentials) (*sts.GetCallerIdentityOutput, error) {
+	cfg, err := a.loadAWSConfigWithCredentials(ctx, creds)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load AWS config using retrieved credentials: %w", err)
+	}
+	var out *sts.GetCallerIdentityOutput
+	err = withSTSTimeout(ctx, a.cfg.STSTimeout, func(ctx context.Context) (err error) {
+		out, err = a.newSTSClient(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		return err
+	})
+	return out, err
+}
+
+// Calls STS GetCallerIdentity with given credentials, logging the identity with -verify
+// and checking that it belongs to the expected AWS account when set
+func (a *Authenticator) VerifyAccount(ctx context.Context, creds aws.Credentials) error {
+	identity, err := a.GetCallerIdentity(ctx, creds)
+	if err != nil {
+		return fmt.Errorf("couldn't verify AWS credentials: %w", err)
+	}
+	if a.cfg.Verify {
+		logger.Info("Verified AWS credentials, the cluster must map this identity in aws-auth or access entries",
+			"arn", aws.ToString(identity.Arn), "account", aws.ToString(identity.Account), "userId", aws.ToString(identity.UserId))
+	}
+	if account := aws.ToString(identity.Account); a.cfg.ExpectedAccount != "" && account != a.cfg.ExpectedAccount {
+		return fmt.Errorf("assumed role %s belongs to AWS account %s, expected %s", aws.ToString(identity.Arn), account, a.cfg.ExpectedAccount)
+	}
+	return nil
+}
+
+// Presigns STS GetCallerIdentity request identifying the EKS cluster with given credentials
+func (a *Authenticator) GetPresignedCallerIdentityURL(ctx context.Context, creds aws.Credentials) (string, error) {
+	region := a.cfg.STSRegion
+	if a.cfg.ClusterRegion != "" {
+		// Sign for the cluster region while AssumeRoleWithWebIdentity keeps using -sts-region
+		region = a.cfg.ClusterRegion
+	}
+	eksSignerCfg, err := a.loadAWSConfigWithCredentials(ctx, creds)
+	if err != nil {
+		return "", a.authError(ErrPresign, region, fmt.Errorf("couldn't load AWS config using retrieved credentials: %w", err))
+	}
+
+	client := a.newSTSClient(eksSignerCfg, func(o *sts.Options) {
+		o.Region = region
+	})
+
+	var presignedURLString *v4.PresignedHTTPRequest
+	err = withSTSTimeout(ctx, a.cfg.STSTimeout, func(ctx context.Context) (err error) {
+		presignedURLString, err = client.PresignGetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}, func(opt *sts.PresignOptions) {
+			opt.Presigner = newCustomHTTPPresignerV4(opt.Presigner, a.presignHeaders(a.presignExpires()))
+		})
+		return err
+	})
+	if err != nil {
+		return "", a.authError(ErrPresign, region, err)
+	}
+	if err := a.validatePresignedURL(presignedURLString.URL); err != nil {
+		return "", a.authError(ErrPresign, region, fmt.Errorf("presigned URL failed validation: %w", err))
+	}
+	return presignedURLString.URL, nil
+}
+
+// Overrides the region requests are signed for, independently of the region used to
+// resolve the endpoint. The SDK copies the signing region from the context into the
+// resolved auth scheme right before signing and presigning.
+func withSigningRegion(region string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SigningRegion", func(
+			ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+		) (middleware.InitializeOutput, middleware.Metadata, error) {
+			return next.HandleInitialize(awsmiddleware.SetSigningRegion(ctx, region), in)
+		}), middleware.After)
+	}
+}
+
+// Returns headers signed into the EKS token, extra headers from -presign-header merged with
+// the required ones. X-Amz-Expires is set from expires, only with the legacy token format.
+func (a *Authenticator) presignHeaders(expires time.Duration) map[string]string {
+	headers := make(map[string]string, len(a.cfg.PresignHeaders)+2)
+	for key, value := range a.cfg.PresignHeaders {
+		headers[key] = value
+	}
+	headers[eksClusterIdHeader] = a.cfg.EKSClusterName
+	if a.cfg.TokenFormat == tokenFormatLegacy {
+		headers["X-Amz-Expires"] = presignExpiresParam(expires)
+	}
+	return headers
+}
+
+// Returns lifetime of the EKS token for the requested one, clamped to the 15 minutes STS
+// and aws-iam-authenticator accept presigned URLs for
+func effectiveTokenExpiration(requested time.Duration) time.Duration {
+	return min(requested, presignedURLExpiration)
+}
+
+// Returns expiration signed into the EKS token as X-Amz-Expires: the effective
+// -token-expiration when set, and otherwise requestPresignParam, which
+// aws-iam-authenticator 0.3.0 and earlier require
+func (a *Authenticator) presignExpires() time.Duration {
+	if a.cfg.Source("token-expiration") == sourceDefault {
+		return requestPresignParam * time.Second
+	}
+	return effectiveTokenExpiration(a.cfg.TokenExpiration)
+}
+
+// Returns X-Amz-Expires value for given expiration, clamped to the maximum accepted by
+// STS. The ExecCredential expiration is computed separately by [tokenExpiration].
+func presignExpiresParam(expiration time.Duration) string {
+	seconds := int(expiration.Seconds())
+	if seconds > maxPresignExpires {
+		seconds = maxPresignExpires
+	}
+	if seconds < 0 {
+		seconds = 0
+	}
+	return strconv.Itoa(seconds)
+}
+
+// Reports whether STS rejected the requested session duration, which happens
+// when it's longer than the maximum session duration of the role
+func isSessionDurationError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationError" &&
+		strings.Contains(apiErr.ErrorMessage(), "DurationSeconds")
+}
+
+// Error returned when STS calls don't complete within the configured timeout
+var errSTSTimeout = errors.New("STS request timed out")
+
+// Runs fn with a context bounded by the STS timeout. Errors caused by the deadline
+// are wrapped in [errSTSTimeout] to distinguish them from authentication failures.
+func withSTSTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	stsCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := fn(stsCtx)
+	if err != nil && errors.Is(stsCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("%w after %s: %w", errSTSTimeout, timeout, err)
+	}
+	return err
+}
+
+// Reports whether STS rejected the web identity token because it has expired
+func isExpiredTokenError(err error) bool {
+	var expiredErr *types.ExpiredTokenException
+	return errors.As(err, &expiredErr)
+}
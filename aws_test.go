@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Returns log entries with the given message
+func logEntries(t *testing.T, logs string, msg string) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", scanner.Text(), err)
+		}
+		if entry["msg"] == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestAWSEndpointOverride(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	logs := captureLogs(t)
+	cfg := loadTestConfig(t, "-role-arn", "arn:aws:iam::123456789012:role/test", "-cluster", "test",
+		"-aws-endpoint", "https://sts.eu-west-1.amazonaws.com")
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := NewAuthenticator(cfg)
+	entries := logEntries(t, logs.String(), "Using custom AWS STS endpoint")
+	if len(entries) != 1 || entries[0]["level"] != "INFO" || entries[0]["endpoint"] != "https://sts.eu-west-1.amazonaws.com" {
+		t.Errorf("endpoint log entries = %v, want one info entry with the endpoint", entries)
+	}
+	awsCfg, err := auth.loadAWSConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.ToString(awsCfg.BaseEndpoint); got != "https://sts.eu-west-1.amazonaws.com" {
+		t.Errorf("BaseEndpoint = %q", got)
+	}
+}
+
+func TestAWSEndpointRejectsMalformed(t *testing.T) {
+	for _, endpoint := range []string{"sts.eu-west-1.amazonaws.com", "https://sts.eu-west-1.amazonaws.com?x=1", "https://[::1"} {
+		cfg := loadTestConfig(t, "-role-arn", "arn:aws:iam::123456789012:role/test", "-cluster", "test", "-aws-endpoint", endpoint)
+		if err := cfg.validate(); err == nil || !strings.HasPrefix(err.Error(), "-aws-endpoint: ") {
+			t.Errorf("validate() with -aws-endpoint %q error = %v, want -aws-endpoint error", endpoint, err)
+		}
+	}
+}
+
+func TestShortSessionNameWarning(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		warn bool
+	}{
+		{[]string{"-session-name", "ci"}, true},
+		{[]string{"-session-name", "argocd-prod"}, false},
+		{[]string{"-session-name", "ci", "-session-name-min-length", "0"}, false},
+	} {
+		logs := captureLogs(t)
+		args := append([]string{"-role-arn", "arn:aws:iam::123456789012:role/test", "-cluster", "test"}, tt.args...)
+		auth := NewAuthenticator(loadTestConfig(t, args...))
+		name, err := auth.GetSessionIdentifier(context.Background())
+		if err != nil || name != tt.args[1] {
+			t.Fatalf("GetSessionIdentifier() with %v = %q, %v", tt.args, name, err)
+		}
+		entries := logEntries(t, logs.String(), "Session name is short and may be too generic for CloudTrail auditing")
+		if got := len(entries) == 1 && entries[0]["level"] == "WARN"; got != tt.warn {
+			t.Errorf("%v: short name warnings = %v, want warning %v", tt.args, entries, tt.warn)
+		}
+	}
+}
+
+func TestIdentityTokenCache(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	token := "header." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp))) + ".signature"
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": token})
+	auth := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test"))
+	auth.metadataHTTPClient = md.client()
+
+	hits := testutil.ToFloat64(identityTokenCacheLookupsTotal.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(identityTokenCacheLookupsTotal.WithLabelValues("miss"))
+	for i := 0; i < 2; i++ {
+		got, err := auth.GetIdentityToken(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.token) != token {
+			t.Errorf("token = %q, want %q", got.token, token)
+		}
+	}
+	if n := len(md.Requests()); n != 1 {
+		t.Errorf("metadata requests = %d, want 1", n)
+	}
+	if got := testutil.ToFloat64(identityTokenCacheLookupsTotal.WithLabelValues("miss")) - misses; got != 1 {
+		t.Errorf("cache misses = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(identityTokenCacheLookupsTotal.WithLabelValues("hit")) - hits; got != 1 {
+		t.Errorf("cache hits = %v, want 1", got)
+	}
+}
+
+func TestTokenExpirationCappedByCredentials(t *testing.T) {
+	expires := time.Now().Add(5 * time.Minute)
+	creds := aws.Credentials{CanExpire: true, Expires: expires}
+	if got, want := tokenExpiration(creds, 15*time.Minute), expires.Add(-time.Minute); !got.Equal(want) {
+		t.Errorf("tokenExpiration = %s, want %s", got, want)
+	}
+}
+
+func TestTokenExpirationClamp(t *testing.T) {
+	const clampWarning = "Requested token expiration exceeds the lifetime of presigned STS URLs"
+	tests := []struct {
+		name        string
+		args        []string
+		wantExpires string
+		wantWarning bool
+		wantLife    time.Duration
+	}{
+		{"default", nil, "60", false, 15 * time.Minute},
+		{"shorter", []string{"-token-expiration", "5m"}, "300", false, 5 * time.Minute},
+		{"maximum", []string{"-token-expiration", "15m"}, "900", false, 15 * time.Minute},
+		{"clamped", []string{"-token-expiration", "30m"}, "900", true, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			cfg := loadTestConfig(t, append([]string{"-role-arn", testRoleARN, "-cluster", "test"}, tt.args...)...)
+			a := NewAuthenticator(cfg)
+
+			if got := strings.Contains(logs.String(), clampWarning); got != tt.wantWarning {
+				t.Errorf("clamp warning logged = %t, want %t, logs: %s", got, tt.wantWarning, logs)
+			}
+			if got := a.presignHeaders(a.presignExpires())["X-Amz-Expires"]; got != tt.wantExpires {
+				t.Errorf("X-Amz-Expires = %s, want %s", got, tt.wantExpires)
+			}
+			// The ExecCredential expires 1 minute before the token for some cushion
+			want := time.Now().Add(tt.wantLife - time.Minute)
+			if got := tokenExpiration(aws.Credentials{}, cfg.TokenExpiration); got.Sub(want).Abs() > time.Second {
+				t.Errorf("tokenExpiration = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTokenExpirationSignedIntoToken(t *testing.T) {
+	captureLogs(t)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-mock", "-token-expiration", "30m")
+	output, err := issueCredential(context.Background(), cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1beta1)
+	if err != nil {
+		t.Fatalf("issueCredential() error = %v", err)
+	}
+	if got := decodeTokenURL(t, output).Query().Get("X-Amz-Expires"); got != "900" {
+		t.Errorf("X-Amz-Expires = %q, want the clamped 900", got)
+	}
+}
+
+func TestPresignExpiresParam(t *testing.T) {
+	tests := []struct {
+		expiration time.Duration
+		want       string
+	}{
+		{60 * time.Second, "60"},
+		{15 * time.Minute, "900"},
+		{30 * time.Minute, "900"},
+		{time.Hour, "900"},
+		{-time.Second, "0"},
+	}
+	for _, tt := range tests {
+		if got := presignExpiresParam(tt.expiration); got != tt.want {
+			t.Errorf("presignExpiresParam(%s) = %s, want %s", tt.expiration, got, tt.want)
+		}
+	}
+}
+
+func TestPresignHeadersClampExpires(t *testing.T) {
+	a := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test"))
+	for _, expires := range []time.Duration{time.Minute, 15 * time.Minute, 2 * time.Hour} {
+		seconds, err := strconv.Atoi(a.presignHeaders(expires)["X-Amz-Expires"])
+		if err != nil {
+			t.Fatalf("X-Amz-Expires for %s: %v", expires, err)
+		}
+		if seconds > maxPresignExpires {
+			t.Errorf("X-Amz-Expires for %s = %d, want at most %d", expires, seconds, maxPresignExpires)
+		}
+	}
+}
+
+func TestNewAuthenticatorHonorsMetadataHost(t *testing.T) {
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+	t.Setenv("GCE_METADATA_HOST", "")
+	auth := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-gcp-metadata-host", md.host()))
+
+	token, err := auth.GetIdentityToken(context.Background())
+	if err != nil {
+		t.Fatalf("GetIdentityToken() error = %v", err)
+	}
+	if string(token.token) != "header.payload.signature" {
+		t.Errorf("token = %q, want the fake metadata server's", token.token)
+	}
+	if n := len(md.Requests()); n != 1 {
+		t.Errorf("metadata requests = %d, want 1", n)
+	}
+}
+
+func TestIdentityTokenFetchedOncePerIssuance(t *testing.T) {
+	captureLogs(t)
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	// A token without readable claims isn't cached, so every issuance needs a new one
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+	srv := newFakeSTSServer(t)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", srv.URL)
+	auth := NewAuthenticator(cfg)
+	auth.metadataHTTPClient = md.client()
+
+	for i := 1; i <= 2; i++ {
+		if _, err := issueCredential(context.Background(), cfg, auth, "session", execCredentialV1); err != nil {
+			t.Fatalf("issueCredential() error = %v", err)
+		}
+		if n := len(md.Requests()); n != i {
+			t.Errorf("identity token requests after %d issuances = %d, want %d", i, n, i)
+		}
+		if n := len(srv.Requests()); n != i {
+			t.Errorf("STS requests after %d issuances = %d, want %d", i, n, i)
+		}
+	}
+}
+
+// Writer sending every log record to a channel, so tests can wait for records
+// logged by goroutines they don't control
+type recordWriter chan string
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestSTSTimeoutOnUnresponsiveEndpoint(t *testing.T) {
+	records := make(recordWriter, 64)
+	prev := logger
+	logger = slog.New(slog.NewJSONHandler(records, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() { logger = prev })
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	// Accepts connections but never responds, like a black-holed egress path
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", "http://"+l.Addr().String(),
+		"-sts-timeout", "1s", "-max-retries", "0")
+
+	start := time.Now()
+	_, err = NewAuthenticator(cfg).GetCredentials(context.Background(), "session", customIdentityTokenRetriever{token: []byte("gcp-token")})
+	if !errors.Is(err, errSTSTimeout) {
+		t.Errorf("GetCredentials() error = %v, want errSTSTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("GetCredentials() took %s, want about the 1s -sts-timeout", elapsed)
+	}
+
+	// The SDK finishes the abandoned call in the background, wait until it's logged
+	// so it doesn't write to the logger of a later test
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case record := <-records:
+			if strings.Contains(record, `"operation":"aws.sts AssumeRoleWithWebIdentity"`) {
+				return
+			}
+		case <-deadline:
+			t.Fatal("abandoned STS call wasn't logged")
+		}
+	}
+}
+
+func TestTokenFileIsReread(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	auth := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-token-file", path))
+	for _, token := range []string{"first.token.value", "rotated.token.value"} {
+		if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := auth.GetIdentityToken(context.Background())
+		if err != nil {
+			t.Fatalf("GetIdentityToken() error = %v", err)
+		}
+		if string(got.token) != token {
+			t.Errorf("token = %q, want %q", got.token, token)
+		}
+	}
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.GetIdentityToken(context.Background()); !errors.Is(err, ErrTokenRetrieval) {
+		t.Errorf("GetIdentityToken() of empty file error = %v, want ErrTokenRetrieval", err)
+	}
+}
+
+func TestStdinTokenRetriever(t *testing.T) {
+	r, err := NewStdinTokenRetriever(strings.NewReader("  piped.token.value\n"))
+	if err != nil {
+		t.Fatalf("NewStdinTokenRetriever() error = %v", err)
+	}
+	// The token is read once and returned on every call
+	for i := 0; i < 2; i++ {
+		if token, err := r.GetIdentityToken(); err != nil || string(token) != "piped.token.value" {
+			t.Errorf("GetIdentityToken() = %q, %v, want piped.token.value", token, err)
+		}
+	}
+	if _, err := NewStdinTokenRetriever(strings.NewReader("\n")); err == nil {
+		t.Error("NewStdinTokenRetriever() accepted empty input")
+	}
+}
+
+// Fake STS client answering AssumeRoleWithWebIdentity and GetCallerIdentity, failing
+// the first calls with the queued errors. Unused methods panic through the nil stsAPI.
+type fakeSTS struct {
+	stsAPI
+	errs      []error  // Errors returned by the next calls, in order
+	tokens    []string // Web identity tokens received
+	sessions  []string // Role session names received
+	account   string
+	callCount int
+}
+
+func (f *fakeSTS) nextErr() error {
+	f.callCount++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *fakeSTS) AssumeRoleWithWebIdentity(_ context.Context, params *sts.AssumeRoleWithWebIdentityInput, _ ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	f.tokens = append(f.tokens, aws.ToString(params.WebIdentityToken))
+	f.sessions = append(f.sessions, aws.ToString(params.RoleSessionName))
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	return &sts.AssumeRoleWithWebIdentityOutput{Credentials: &types.Credentials{
+		AccessKeyId:     aws.String("ASIAFAKEACCESSKEY000"),
+		SecretAccessKey: aws.String("fake-secret"),
+		SessionToken:    aws.String("fake-session-token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func (f *fakeSTS) GetCallerIdentity(context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(f.account),
+		Arn:     aws.String("arn:aws:sts::" + f.account + ":assumed-role/test/session"),
+	}, nil
+}
+
+// Writes identity token to a file read with -token-file, returning its path
+func writeTokenFile(t *testing.T, token string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetCredentialsRetriesExpiredToken(t *testing.T) {
+	captureLogs(t)
+	expired := &types.ExpiredTokenException{Message: aws.String("Token expired")}
+	tests := []struct {
+		name      string
+		args      []string
+		wantErr   bool
+		wantCalls int
+	}{
+		{"retried once", nil, false, 2},
+		{"retry disabled", []string{"-retry-expired-token=false"}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-role-arn", testRoleARN, "-cluster", "test",
+				"-token-file", writeTokenFile(t, "gcp-token"), "-max-retries", "0"}, tt.args...)
+			cfg := loadTestConfig(t, args...)
+			fake := &fakeSTS{errs: []error{expired}}
+			auth := NewAuthenticator(cfg, WithSTSClient(fake))
+
+			token, err := auth.GetIdentityToken(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			creds, err := auth.GetCredentials(context.Background(), "session", token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fake.callCount != tt.wantCalls {
+				t.Errorf("AssumeRoleWithWebIdentity calls = %d, want %d", fake.callCount, tt.wantCalls)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrAssumeRole) || !isExpiredTokenError(err) {
+					t.Errorf("GetCredentials() error = %v, want ErrAssumeRole wrapping ExpiredTokenException", err)
+				}
+				return
+			}
+			if creds.AccessKeyID != "ASIAFAKEACCESSKEY000" {
+				t.Errorf("AccessKeyID = %q", creds.AccessKeyID)
+			}
+			for _, got := range fake.tokens {
+				if got != "gcp-token" {
+					t.Errorf("web identity token = %q, want %q", got, "gcp-token")
+				}
+			}
+		})
+	}
+}
+
+func TestWithSTSClient(t *testing.T) {
+	captureLogs(t)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test",
+		"-token-file", writeTokenFile(t, "gcp-token"), "-expected-aws-account", "123456789012")
+	fake := &fakeSTS{account: "123456789012"}
+	auth := NewAuthenticator(cfg, WithSTSClient(fake))
+
+	token, err := auth.GetIdentityToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := auth.GetCredentials(context.Background(), "argocd-session", token)
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if !slices.Equal(fake.sessions, []string{"argocd-session"}) || !slices.Equal(fake.tokens, []string{"gcp-token"}) {
+		t.Errorf("AssumeRoleWithWebIdentity sessions, tokens = %q, %q", fake.sessions, fake.tokens)
+	}
+	if err := auth.VerifyAccount(context.Background(), creds); err != nil {
+		t.Errorf("VerifyAccount() error = %v", err)
+	}
+
+	fake.account = "210987654321"
+	if err := auth.VerifyAccount(context.Background(), creds); err == nil || !strings.Contains(err.Error(), "expected 123456789012") {
+		t.Errorf("VerifyAccount() error = %v, want account mismatch", err)
+	}
+}
+
+func TestProfileCredentialSource(t *testing.T) {
+	captureLogs(t)
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("[profile break-glass]\naws_access_key_id = AKIAPROFILEKEY000000\naws_secret_access_key = profile-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	srv := newFakeSTSServer(t)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", srv.URL,
+		"-credential-source", "profile:break-glass", "-session-name", "argocd-session", "-assume-role-duration", "30m")
+	auth := NewAuthenticator(cfg)
+
+	sessionIdentifier, err := auth.GetSessionIdentifier(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := auth.GetCredentials(context.Background(), sessionIdentifier, customIdentityTokenRetriever{})
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if creds.AccessKeyID != "ASIAFAKEACCESSKEY000" {
+		t.Errorf("AccessKeyID = %q, want the assumed role's", creds.AccessKeyID)
+	}
+	requests := srv.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("STS requests = %d, want 1", len(requests))
+	}
+	form := requests[0].form
+	if form.Get("Action") != "AssumeRole" || form.Get("RoleArn") != testRoleARN ||
+		form.Get("RoleSessionName") != "argocd-session" || form.Get("DurationSeconds") != "1800" {
+		t.Errorf("STS request form = %v, want AssumeRole of %s as argocd-session for 1800s", form, testRoleARN)
+	}
+	if !strings.Contains(requests[0].authorization, "Credential=AKIAPROFILEKEY000000/") {
+		t.Errorf("Authorization = %q, want signed with the profile's access key", requests[0].authorization)
+	}
+}
+
+func TestSTSUserAgent(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	captureLogs(t)
+	srv := newFakeSTSServer(t)
+	version = "v1.2.3"
+	t.Cleanup(func() { version = "" })
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", srv.URL, "-app-id", "argocd-prod")
+
+	_, err := NewAuthenticator(cfg).GetCredentials(context.Background(), "session", customIdentityTokenRetriever{token: []byte("gcp-token")})
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	requests := srv.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("STS requests = %d, want 1", len(requests))
+	}
+	userAgent := strings.Fields(requests[0].userAgent)
+	for _, want := range []string{"app/argocd-prod", programName + "/v1.2.3"} {
+		if !slices.Contains(userAgent, want) {
+			t.Errorf("User-Agent %q doesn't contain %s", requests[0].userAgent, want)
+		}
+	}
+}
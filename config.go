@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Prefix of environment variables setting flag values, e.g. K8S_AUTH_GKE_WLI_EKS_ROLE_ARN for -role-arn
+const envPrefix = "K8S_AUTH_GKE_WLI_EKS_"
+
+// Origins of configuration values, from the lowest to the highest precedence
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// Maximum length of the application ID recommended by AWS SDKs
+const maxAppIDLength = 50
+
+// Default audience of the GCP identity token, rarely what the role trust policy expects
+const defaultAudience = "gcp"
+
+// Role session names accepted by STS
+var sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// Program configuration merged from defaults, config file, environment variables and flags
+type Config struct {
+	AWSRoleARN        string
+	CredentialSource  string
+	EKSClusterName    string
+	ExpectedAccount   string
+	Verify            bool
+	STSRegion         string
+	STSRegionMap      string
+	ClusterRegion     string
+	Partition         string
+	AWSEndpoint       string
+	SigningRegion     string
+	STSVPCEndpoint    string
+	PresignHeaders    headersValue
+	Audience          string
+	VerifyAudience    bool
+	GCPTokenFormat    string
+	MetadataHost      string
+	TokenFile         string
+	TokenStdin        bool
+	APIVersion        string
+	OutputPath        string
+	OutputFormat      string
+	TokenFormat       string
+	PrintURL          bool
+	Quiet             bool
+	MaxRetries        int
+	MetadataRetries   int
+	RetryBackoff      time.Duration
+	RetryExpiredToken bool
+	RetryHint         bool
+	HTTPTimeout       time.Duration
+	STSTimeout        time.Duration
+	SessionDuration   time.Duration
+	TokenExpiration   time.Duration
+	Timeout           time.Duration
+	ProxyURL          string
+	CABundle          string
+	LogFile           string
+	AuditLog          string
+	AppID             string
+	LogLevel          string
+	OTLPEndpoint      string
+	SessionNameHash   bool
+	SessionNameFormat string
+	SessionName       string
+	SessionNameMinLen int
+	PrintFeatures     bool
+	PrintVersion      bool
+	PrintConfig       bool
+	PrintEffective    bool
+	ProbeMetadata     bool
+	DryRun            bool
+	SelfTest          bool
+	Mock              bool
+	ValidateConfig    bool
+	Serve             string
+	ServeTokenFile    string
+	ConfigFile        string
+
+	fs         *flag.FlagSet
+	sources    map[string]string // Origin of each value keyed by flag name
+	warnings   []string          // Deprecation warnings collected while loading
+	deprecated map[string]bool   // Deprecated flags already warned about
+}
+
+// Registers configuration flags in the flag set
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.AWSRoleARN, "role-arn", "", "AWS role ARN to assume (required, optional with -credential-source ambient)")
+	fs.StringVar(&c.CredentialSource, "credential-source", credentialSourceWebIdentity, "Source of the AWS credentials, web-identity (GCP identity token), ambient (default AWS credential chain) or profile:<name> (AssumeRole with credentials of a shared config profile) (optional)")
+	fs.StringVar(&c.EKSClusterName, "cluster", "", "AWS cluster name for which we create credentials (required)")
+	fs.StringVar(&c.ExpectedAccount, "expected-aws-account", "", "AWS account ID the assumed role must belong to, verified with STS GetCallerIdentity before presigning (optional)")
+	fs.BoolVar(&c.Verify, "verify", false, "Call STS GetCallerIdentity with the assumed credentials and log the identity before presigning (optional)")
+	fs.StringVar(&c.STSRegion, "sts-region", defaultSTSRegion, "AWS STS region to which requests are made, or auto to select the region nearest to the GCE zone (optional)")
+	fs.StringVar(&c.STSRegionMap, "sts-region-map", "", "Comma separated gcp-region=aws-region pairs overriding the built-in mapping used with -sts-region auto (optional)")
+	fs.StringVar(&c.ClusterRegion, "cluster-region", "", "AWS region for which the EKS token (presigned STS URL) is signed, defaults to -sts-region (optional)")
+	fs.StringVar(&c.Partition, "partition", "", "AWS partition, e.g. aws-cn or aws-us-gov, defaults to the partition of -role-arn or -sts-region (optional)")
+	fs.StringVar(&c.AWSEndpoint, "aws-endpoint", "", "Custom AWS STS endpoint URL, e.g. https://sts.eu-west-1.amazonaws.com (optional)")
+	fs.StringVar(&c.SigningRegion, "signing-region", "", "AWS region STS requests and the EKS token are signed for instead of -sts-region and -cluster-region, e.g. when a proxy behind -aws-endpoint validates signatures for another region (optional)")
+	c.PresignHeaders = headersValue{}
+	fs.StringVar(&c.STSVPCEndpoint, "sts-vpc-endpoint", "", "Host of an STS VPC endpoint (PrivateLink) to send STS requests to, while signing them for the regional STS host (optional)")
+	fs.Var(c.PresignHeaders, "presign-header", "Extra key=value header signed into the EKS token (presigned STS URL), repeatable (optional)")
+	fs.StringVar(&c.Audience, "audience", defaultAudience, "Audience of the GCP identity token, must match the audience expected by the AWS role trust policy (optional)")
+	fs.BoolVar(&c.VerifyAudience, "verify-audience", false, "Fail before calling STS when the audience of the GCP identity token doesn't match -audience (optional)")
+	fs.StringVar(&c.GCPTokenFormat, "gcp-token-format", "full", "Format of the GCP identity token, full (with instance details) or standard (optional)")
+	fs.StringVar(&c.MetadataHost, "gcp-metadata-host", "", "GCP metadata server host[:port], e.g. of a metadata proxy, overriding GCE_METADATA_HOST (optional)")
+	fs.StringVar(&c.TokenFile, "token-file", "", "Read the identity token from this file, re-read on every STS call, instead of GCP metadata (optional)")
+	fs.BoolVar(&c.TokenStdin, "token-stdin", false, "Read the identity token from stdin once at startup instead of GCP metadata (optional)")
+	fs.StringVar(&c.APIVersion, "api-version", "v1beta1", "ExecCredential API version to emit, v1 or v1beta1. Takes precedence over KUBERNETES_EXEC_INFO, which is used when not set (optional)")
+	fs.StringVar(&c.OutputPath, "output", "", "Write the ExecCredential to this file instead of stdout (optional)")
+	fs.StringVar(&c.OutputFormat, "output-format", outputFormatExecCredential, "Format of the issued credential, exec-credential, aws-credential-process for the AWS CLI/SDK credential_process, env for shell exports or json (optional)")
+	fs.StringVar(&c.TokenFormat, "token-format", tokenFormatLegacy, "Format of the EKS token, legacy (with X-Amz-Expires) or eks (without it) (optional)")
+	fs.BoolVar(&c.PrintURL, "print-url", false, "Log the presigned STS GetCallerIdentity URL and print it to stderr before it's encoded into the EKS token, requires -log-level debug (optional)")
+	fs.BoolVar(&c.Quiet, "quiet", false, "Don't log anything and don't print the output file path with -output, so that only the credential is written (optional)")
+	fs.IntVar(&c.MaxRetries, "max-retries", 2, "Maximum number of retries of transient GCP metadata and AWS STS failures (optional)")
+	fs.IntVar(&c.MetadataRetries, "gcp-metadata-retries", 0, "Maximum number of retries of transient GCP metadata failures, defaults to -max-retries (optional)")
+	durationVar(fs, &c.RetryBackoff, "retry-backoff", 500*time.Millisecond, "Base delay between retries, doubled with jitter on every attempt (optional)")
+	fs.BoolVar(&c.RetryExpiredToken, "retry-expired-token", true, "Fetch a new GCP token and retry once when STS reports it as expired (optional)")
+	fs.BoolVar(&c.RetryHint, "retry-hint", false, "Add a structured retry_hint to the error logged when STS fails, for wrapping tooling to decide whether and when to retry (optional)")
+	durationVar(fs, &c.HTTPTimeout, "http-timeout", 1*time.Second, "Timeout of GCP metadata server requests (optional)")
+	durationVar(fs, &c.STSTimeout, "sts-timeout", 30*time.Second, "Timeout of AWS STS calls, including retries (optional)")
+	durationVar(fs, &c.SessionDuration, "assume-role-duration", 1*time.Hour, "Duration of the assumed role session, between 15m and 12h and at most the maximum session duration of the role (optional)")
+	durationVar(fs, &c.TokenExpiration, "token-expiration", presignedURLExpiration, "Lifetime of the EKS token stamped on the ExecCredential, clamped to the 15m STS accepts presigned URLs for (optional)")
+	durationVar(fs, &c.Timeout, "timeout", 0, "Overall deadline for issuing a credential, 0 for none (optional)")
+	fs.StringVar(&c.ProxyURL, "proxy-url", "", "Proxy for outbound HTTP requests, overriding HTTP_PROXY and HTTPS_PROXY (optional)")
+	fs.StringVar(&c.CABundle, "ca-bundle", "", "PEM bundle of CA certificates trusted in addition to the system ones for AWS STS and GCP metadata TLS connections, e.g. of a TLS-inspecting proxy (optional)")
+	fs.StringVar(&c.LogFile, "log-file", "", "Append logs to this file instead of stderr (optional)")
+	fs.StringVar(&c.AppID, "app-id", "", "Application ID added to the User-Agent of AWS STS requests, e.g. to tell sessions apart in CloudTrail (optional)")
+	fs.StringVar(&c.AuditLog, "audit-log", "", "Append a JSON line recording every issued credential, without the credential itself, to this file (optional)")
+	fs.StringVar(&c.LogLevel, "log-level", "info", "Log level, one of debug, info, warn or error (optional)")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint URL to export traces of the auth flow to, e.g. http://localhost:4318 (optional)")
+	fs.BoolVar(&c.SessionNameHash, "session-name-hash", false, "Use a hash of GCP project ID and hostname as AWS session name (optional)")
+	fs.StringVar(&c.SessionNameFormat, "session-name-format", "{project}-{hostname}", "Template of the AWS session name generated from GCP metadata, referencing {project} and {hostname} (optional)")
+	fs.StringVar(&c.SessionName, "session-name", "", "AWS role session name, overriding the one generated from GCP metadata (optional)")
+	fs.IntVar(&c.SessionNameMinLen, "session-name-min-length", 8, "Warn when -session-name is shorter than this, as generic session names hurt CloudTrail auditing. 0 disables the warning (optional)")
+	fs.BoolVar(&c.PrintFeatures, "features", false, "Print a JSON list of capabilities supported by this build and exit (optional)")
+	fs.BoolVar(&c.PrintVersion, "version", false, "Print the version of this build and exit (optional)")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "Log the effective configuration and the origin of each value (optional)")
+	fs.BoolVar(&c.PrintEffective, "print-effective-config", false, "Print the effective configuration and the origin of each value as JSON to stderr and exit (optional)")
+	fs.BoolVar(&c.ProbeMetadata, "probe-metadata", false, "Check GCP metadata server reachability and identity token issuance, print results and exit (optional)")
+	fs.BoolVar(&c.DryRun, "dry-run", false, "Validate the whole pipeline up to a real STS GetCallerIdentity call, print a summary to stderr and exit without emitting a credential (optional)")
+	fs.BoolVar(&c.SelfTest, "selftest", false, "Check GCP metadata, identity token, STS AssumeRoleWithWebIdentity and presigning, print a pass/fail line per step to stderr and exit (optional)")
+	fs.BoolVar(&c.Mock, "mock", false, "Emit a fake but syntactically valid ExecCredential without contacting GCP or AWS, for local development (optional)")
+	fs.BoolVar(&c.ValidateConfig, "validate-config", false, "Validate configuration and GCP session identifier creation, log what would be used and exit without calling STS (optional)")
+	fs.StringVar(&c.Serve, "serve", "", "Listen on this address, e.g. :8080, serving ExecCredentials on /credentials and Prometheus metrics on /metrics instead of printing a single credential (optional)")
+	fs.StringVar(&c.ServeTokenFile, "serve-token-file", "", "File with a bearer token required in the Authorization header of /credentials requests with -serve, re-read on every request (optional)")
+	fs.StringVar(&c.ConfigFile, "config", "", "Path to a JSON config file with flag names as keys (optional)")
+	registerAliases(fs)
+}
+
+// Loads configuration from the process command line, environment variables and config file
+func LoadFromCommandLine() (*Config, error) {
+	c := &Config{}
+	if err := c.LoadFromFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Loads configuration from args parsed with flags registered in fs, environment variables
+// and config file. Values are applied in order of precedence: defaults, config file,
+// environment variables and explicitly set command line flags. Neither the Config nor
+// the flag set may have been used to load configuration before.
+func (c *Config) LoadFromFlags(fs *flag.FlagSet, args []string) error {
+	if c.fs != nil {
+		return errors.New("configuration already loaded")
+	}
+	if fs.Lookup("role-arn") != nil {
+		return errors.New("flag set already has configuration flags registered")
+	}
+	c.fs = fs
+	c.sources = map[string]string{}
+	c.registerFlags(c.fs)
+	c.fs.Usage = c.printUsage
+	if err := c.fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.resolveAliases(); err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	c.fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// Config file location itself can only come from a flag or environment variable
+	if !explicit["config"] {
+		if path, ok := os.LookupEnv(envName("config")); ok {
+			c.ConfigFile = path
+		}
+	}
+	if c.ConfigFile != "" {
+		values, err := readConfigFile(c.ConfigFile)
+		if err != nil {
+			return err
+		}
+		for name, value := range values {
+			if d, ok := lookupDeprecated(name); ok && d.replacement != "" {
+				c.warnDeprecated(d, fmt.Sprintf("config key %q", name))
+				if newValue, ok := values[d.replacement]; ok && newValue != value {
+					return fmt.Errorf("config file %s: %q conflicts with %q", c.ConfigFile, name, d.replacement)
+				}
+				name = d.replacement
+			}
+			if name == "config" || c.fs.Lookup(name) == nil {
+				return fmt.Errorf("config file %s: unknown key %q", c.ConfigFile, name)
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := c.fs.Set(name, value); err != nil {
+				return fmt.Errorf("config file %s: invalid value for %q: %w", c.ConfigFile, name, err)
+			}
+			c.sources[name] = sourceFile
+		}
+	}
+
+	var envErr error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if isAlias(f.Name) {
+			return
+		}
+		if explicit[f.Name] {
+			c.sources[f.Name] = sourceFlag
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if aliasValue, aliasEnv, aliasOk := c.lookupAliasEnv(f.Name); aliasOk {
+			if ok && aliasValue != value {
+				envErr = errors.Join(envErr, fmt.Errorf("%s conflicts with %s", aliasEnv, envName(f.Name)))
+				return
+			}
+			value, ok = aliasValue, true
+		}
+		if !ok {
+			return
+		}
+		if err := c.fs.Set(f.Name, value); err != nil {
+			envErr = errors.Join(envErr, fmt.Errorf("invalid value for %s: %w", envName(f.Name), err))
+			return
+		}
+		c.sources[f.Name] = sourceEnv
+	})
+	if envErr != nil {
+		return envErr
+	}
+	if c.Source("gcp-metadata-retries") == sourceDefault {
+		c.MetadataRetries = c.MaxRetries
+	}
+	c.warnRemovedFlags()
+	return nil
+}
+
+// Returns name of the environment variable setting given flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Reads JSON config file and returns its values as flag strings keyed by flag name
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[name] = v
+		case bool, json.Number:
+			values[name] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("config file %s: key %q must be a string, number or boolean", path, name)
+		}
+	}
+	return values, nil
+}
+
+// Logs warnings collected while loading configuration
+func (c *Config) logWarnings() {
+	for _, warning := range c.warnings {
+		logger.Warn(warning)
+	}
+}
+
+// Returns AWS partition set by -partition, or the partition of the role ARN or STS region
+func (c *Config) partition() string {
+	if c.Partition != "" {
+		return c.Partition
+	}
+	if roleARN, err := arn.Parse(c.AWSRoleARN); err == nil {
+		return roleARN.Partition
+	}
+	return regionPartition(c.STSRegion)
+}
+
+// Checks that the partition is known, and that the role ARN and regions belong to it
+func (c *Config) validatePartition() []error {
+	if c.Partition != "" {
+		if _, ok := awsPartitions[c.Partition]; !ok {
+			return []error{&ValidationError{Flag: "partition", Err: fmt.Errorf("unknown partition %q", c.Partition)}}
+		}
+		if roleARN, err := arn.Parse(c.AWSRoleARN); err == nil && roleARN.Partition != c.Partition {
+			return []error{&ValidationError{Flag: "partition", Err: fmt.Errorf("%q doesn't match partition %q of -role-arn", c.Partition, roleARN.Partition)}}
+		}
+	}
+	partition := c.partition()
+	var errs []error
+	for _, region := range []struct{ flag, name string }{{"sts-region", c.STSRegion}, {"cluster-region", c.ClusterRegion}} {
+		if region.name == "" || region.name == stsRegionAuto {
+			continue
+		}
+		if p := regionPartition(region.name); p != partition {
+			errs = append(errs, &ValidationError{Flag: region.flag, Err: fmt.Errorf("region %s is in partition %s, not %s", region.name, p, partition)})
+		}
+	}
+	return errs
+}
+
+// Reports whether AWS credentials are obtained with the GCP identity token
+func (c *Config) usesWebIdentity() bool {
+	return c.CredentialSource == credentialSourceWebIdentity
+}
+
+// Returns the STS operation retrieving AWS credentials from the configured source, or ""
+// when no STS call is made, in mock mode or with ambient credentials used as they are
+func (c *Config) credentialsOperation() string {
+	switch {
+	case c.Mock:
+		return ""
+	case c.usesWebIdentity():
+		return "AssumeRoleWithWebIdentity"
+	case c.AWSRoleARN != "":
+		return "AssumeRole"
+	}
+	return ""
+}
+
+// Reports whether the assumed credentials are checked with STS GetCallerIdentity before presigning
+func (c *Config) verifiesIdentity() bool {
+	return c.Verify || c.ExpectedAccount != ""
+}
+
+// Returns origin of the value of given flag
+func (c *Config) Source(name string) string {
+	if source, ok := c.sources[name]; ok {
+		return source
+	}
+	return sourceDefault
+}
+
+// Flags whose values are URLs that may embed credentials
+var urlFlags = map[string]bool{
+	"proxy-url":    true,
+	"aws-endpoint": true,
+}
+
+// Returns value of given flag safe for printing, with URL passwords redacted
+func (c *Config) redactedValue(f *flag.Flag) string {
+	value := f.Value.String()
+	if !urlFlags[f.Name] || value == "" {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return "REDACTED"
+	}
+	return u.Redacted()
+}
+
+// Effective value of a single flag and its origin
+type effectiveValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Calls fn with the effective value of every flag in lexicographical order, with URL
+// passwords redacted. Shared by -print-config and -print-effective-config.
+func (c *Config) visitEffective(fn func(name string, v effectiveValue)) {
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if isAlias(f.Name) {
+			return
+		}
+		fn(f.Name, effectiveValue{Value: c.redactedValue(f), Source: c.Source(f.Name)})
+	})
+}
+
+// Logs effective configuration along with the origin of each value
+func (c *Config) logEffective() {
+	var attrs []any
+	c.visitEffective(func(name string, v effectiveValue) {
+		attrs = append(attrs, slog.Group(name, "value", v.Value, "source", v.Source))
+	})
+	logger.Info("Effective configuration", attrs...)
+}
+
+// Writes effective configuration as a JSON object keyed by flag name
+func (c *Config) printEffective(w io.Writer) error {
+	values := map[string]effectiveValue{}
+	c.visitEffective(func(name string, v effectiveValue) {
+		values[name] = v
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
+
+// Problem with the value of a single flag
+type ValidationError struct {
+	Flag string // Flag name without the leading dash
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("-%s: %s", e.Flag, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Reports whether target is [ErrValidation]
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Returns individual problems reported by [Config.validate]
+func ValidationErrors(err error) []*ValidationError {
+	var problems []*ValidationError
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			problems = append(problems, ValidationErrors(err)...)
+		}
+		return problems
+	}
+	var problem *ValidationError
+	if errors.As(err, &problem) {
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// Validates configuration values. All problems are reported together as
+// [ValidationError] values joined with [errors.Join].
+func (c *Config) validate() error {
+	var errs []error
+	invalid := func(flag string, err error) {
+		errs = append(errs, &ValidationError{Flag: flag, Err: err})
+	}
+
+	errs = append(errs, c.validateFlagCombinations()...)
+	profile, isProfile := credentialSourceProfile(c.CredentialSource)
+	switch {
+	case c.CredentialSource == credentialSourceWebIdentity, c.CredentialSource == credentialSourceAmbient:
+	case isProfile && profile == "":
+		invalid("credential-source", errors.New("profile name is missing, expected profile:<name>"))
+	case !isProfile:
+		invalid("credential-source", fmt.Errorf("unsupported source %q, expected %s, %s or %s<name>", c.CredentialSource,
+			credentialSourceWebIdentity, credentialSourceAmbient, credentialSourceProfilePrefix))
+	}
+	if c.AWSRoleARN == "" {
+		if c.CredentialSource != credentialSourceAmbient {
+			invalid("role-arn", errors.New("is required"))
+		}
+	} else if err := validateRoleARN(c.AWSRoleARN); err != nil {
+		invalid("role-arn", err)
+	}
+	if c.EKSClusterName == "" {
+		invalid("cluster", errors.New("is required"))
+	} else if clusterName, err := parseClusterName(c.EKSClusterName); err != nil {
+		invalid("cluster", err)
+	} else {
+		c.EKSClusterName = clusterName
+	}
+	if c.ExpectedAccount != "" && !awsAccountIDPattern.MatchString(c.ExpectedAccount) {
+		invalid("expected-aws-account", fmt.Errorf("%q is not a valid AWS account id, expected 12 digits", c.ExpectedAccount))
+	}
+	errs = append(errs, c.validateMetadataAccess()...)
+	switch c.OutputFormat {
+	case outputFormatExecCredential, outputFormatCredentialProcess, outputFormatEnv, outputFormatJSON:
+	default:
+		invalid("output-format", fmt.Errorf("unsupported format %q, expected %s, %s, %s or %s", c.OutputFormat,
+			outputFormatExecCredential, outputFormatCredentialProcess, outputFormatEnv, outputFormatJSON))
+	}
+	switch c.TokenFormat {
+	case tokenFormatLegacy, tokenFormatEKS:
+	default:
+		invalid("token-format", fmt.Errorf("unsupported format %q, expected %s or %s", c.TokenFormat, tokenFormatLegacy, tokenFormatEKS))
+	}
+	if c.SessionName != "" && !sessionNamePattern.MatchString(c.SessionName) {
+		invalid("session-name", fmt.Errorf("%q must be 2-64 characters of letters, digits and +=,.@_-", c.SessionName))
+	}
+	if err := validateSessionNameFormat(c.SessionNameFormat); err != nil {
+		invalid("session-name-format", err)
+	}
+	if c.SessionNameMinLen < 0 {
+		invalid("session-name-min-length", errors.New("can't be negative"))
+	}
+	if c.MaxRetries < 0 {
+		invalid("max-retries", errors.New("can't be negative"))
+	}
+	if len(c.AppID) > maxAppIDLength {
+		invalid("app-id", fmt.Errorf("can be at most %d characters", maxAppIDLength))
+	}
+	if c.PrintURL {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.LogLevel)); err == nil && level > slog.LevelDebug {
+			invalid("print-url", errors.New("requires -log-level debug"))
+		}
+	}
+	if c.MetadataRetries < 0 && c.Source("gcp-metadata-retries") != sourceDefault {
+		// A negative inherited -max-retries is already reported
+		invalid("gcp-metadata-retries", errors.New("can't be negative"))
+	}
+	errs = append(errs, c.validatePartition()...)
+	errs = append(errs, c.validateDurations()...)
+	if _, err := parseRegionMap(c.STSRegionMap); err != nil {
+		invalid("sts-region-map", err)
+	}
+	if c.AWSEndpoint != "" {
+		if err := validateEndpointURL(c.AWSEndpoint); err != nil {
+			invalid("aws-endpoint", err)
+		}
+	}
+	if c.STSVPCEndpoint != "" {
+		if err := validateHostPort(c.STSVPCEndpoint); err != nil {
+			invalid("sts-vpc-endpoint", err)
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		if err := validateEndpointURL(c.OTLPEndpoint); err != nil {
+			invalid("otlp-endpoint", err)
+		}
+	}
+	if c.ProxyURL != "" {
+		if err := validateProxyURL(c.ProxyURL); err != nil {
+			invalid("proxy-url", err)
+		}
+	}
+	if _, err := execCredentialAPIVersion(c.APIVersion); err != nil {
+		invalid("api-version", err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validates values used to reach the GCP metadata server and request identity tokens from it
+func (c *Config) validateMetadataAccess() []error {
+	var errs []error
+	if c.Audience == "" {
+		errs = append(errs, &ValidationError{Flag: "audience", Err: errors.New("can't be empty")})
+	}
+	if c.GCPTokenFormat != "full" && c.GCPTokenFormat != "standard" {
+		errs = append(errs, &ValidationError{Flag: "gcp-token-format", Err: fmt.Errorf("unsupported format %q, expected full or standard", c.GCPTokenFormat)})
+	}
+	if c.MetadataHost != "" {
+		if err := validateHostPort(c.MetadataHost); err != nil {
+			errs = append(errs, &ValidationError{Flag: "gcp-metadata-host", Err: err})
+		}
+	}
+	if c.CABundle != "" {
+		if _, err := loadCABundle(c.CABundle); err != nil {
+			errs = append(errs, &ValidationError{Flag: "ca-bundle", Err: err})
+		}
+	}
+	return errs
+}
+
+// Validates configuration of -probe-metadata, which only contacts the GCP metadata server
+// and therefore doesn't need the role and cluster
+func (c *Config) validateProbe() error {
+	errs := c.validateFlagCombinations()
+	errs = append(errs, c.validateMetadataAccess()...)
+	errs = append(errs, c.validateDurations()...)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+)
+
+// Credential sources selected by -credential-source
+const (
+	credentialSourceWebIdentity   = "web-identity"
+	credentialSourceAmbient       = "ambient"
+	credentialSourceProfilePrefix = "profile:"
+)
+
+// Returns name of the shared config profile of a profile:<name> credential source
+func credentialSourceProfile(source string) (string, bool) {
+	return strings.CutPrefix(source, credentialSourceProfilePrefix)
+}
+
+// Source of the AWS credentials used to presign EKS tokens
+type CredentialSource interface {
+	// Returns AWS config whose credentials provider yields the credentials
+	Credentials(ctx context.Context) (aws.Config, error)
+}
+
+var (
+	_ CredentialSource = (*WebIdentityCredentialSource)(nil)
+	_ CredentialSource = (*AmbientCredentialSource)(nil)
+	_ CredentialSource = (*ProfileCredentialSource)(nil)
+)
+
+// Assumes the configured AWS role with AssumeRoleWithWebIdentity using an identity token
+type WebIdentityCredentialSource struct {
+	auth              *Authenticator
+	sessionIdentifier string
+	token             stscreds.IdentityTokenRetriever
+}
+
+// Returns credential source assuming the configured role with given identity token
+func (a *Authenticator) newWebIdentityCredentialSource(sessionIdentifier string, token customIdentityTokenRetriever) CredentialSource {
+	return &WebIdentityCredentialSource{auth: a, sessionIdentifier: sessionIdentifier, token: token}
+}
+
+func (s *WebIdentityCredentialSource) Credentials(ctx context.Context) (aws.Config, error) {
+	awsCfg, err := s.auth.loadAWSConfig(ctx)
+	if err != nil {
+		return awsCfg, fmt.Errorf("failed to load default AWS config: %w", err)
+	}
+	awsCfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+		s.auth.newSTSClient(awsCfg),
+		s.auth.cfg.AWSRoleARN,
+		s.token,
+		func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = s.sessionIdentifier
+			o.Duration = s.auth.cfg.SessionDuration
+		}),
+	)
+	return awsCfg, nil
+}
+
+// Uses AWS credentials of the environment (environment variables, shared config, IRSA
+// or EC2 instance profile), optionally assuming the configured role with AssumeRole
+type AmbientCredentialSource struct {
+	auth              *Authenticator
+	sessionIdentifier string
+}
+
+// Returns credential source using ambient AWS credentials, the identity token is ignored
+func (a *Authenticator) newAmbientCredentialSource(sessionIdentifier string, _ customIdentityTokenRetriever) CredentialSource {
+	return &AmbientCredentialSource{auth: a, sessionIdentifier: sessionIdentifier}
+}
+
+func (s *AmbientCredentialSource) Credentials(ctx context.Context) (aws.Config, error) {
+	awsCfg, err := s.auth.loadAWSConfig(ctx)
+	if err != nil {
+		return awsCfg, fmt.Errorf("failed to load default AWS config: %w", err)
+	}
+	if s.auth.cfg.AWSRoleARN == "" {
+		return awsCfg, nil
+	}
+	return s.auth.withAssumedRole(awsCfg, s.sessionIdentifier), nil
+}
+
+// Assumes the configured AWS role with AssumeRole using base credentials of a shared
+// config profile, e.g. of a break-glass IAM user, or of the standard environment variables
+type ProfileCredentialSource struct {
+	auth              *Authenticator
+	profile           string
+	sessionIdentifier string
+}
+
+// Returns function creating credential sources using base credentials of given profile
+func (a *Authenticator) newProfileCredentialSource(profile string) func(string, customIdentityTokenRetriever) CredentialSource {
+	return func(sessionIdentifier string, _ customIdentityTokenRetriever) CredentialSource {
+		return &ProfileCredentialSource{auth: a, profile: profile, sessionIdentifier: sessionIdentifier}
+	}
+}
+
+func (s *ProfileCredentialSource) Credentials(ctx context.Context) (aws.Config, error) {
+	awsCfg, err := s.auth.loadAWSConfigFor(ctx, config.WithSharedConfigProfile(s.profile))
+	if err != nil {
+		return awsCfg, fmt.Errorf("failed to load AWS config of profile %s: %w", s.profile, err)
+	}
+	return s.auth.withAssumedRole(awsCfg, s.sessionIdentifier), nil
+}
+
+// Returns AWS config assuming the configured role with AssumeRole using the credentials of awsCfg
+func (a *Authenticator) withAssumedRole(awsCfg aws.Config, sessionIdentifier string) aws.Config {
+	awsCfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(
+		a.newSTSClient(awsCfg),
+		a.cfg.AWSRoleARN,
+		func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionIdentifier
+			o.Duration = a.cfg.SessionDuration
+		}),
+	)
+	return awsCfg
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Flag that was renamed or is scheduled for removal
+type deprecatedFlag struct {
+	name        string
+	replacement string // New name of the flag, empty when the flag is going away without a replacement
+}
+
+// Deprecated flags. Renamed flags stay accepted under their old name, in the config
+// file and environment variables as well, until they are removed.
+var deprecatedFlags = []deprecatedFlag{
+	{name: "rolearn", replacement: "role-arn"},
+	{name: "stsregion", replacement: "sts-region"},
+}
+
+// Returns deprecation of given flag
+func lookupDeprecated(name string) (deprecatedFlag, bool) {
+	for _, d := range deprecatedFlags {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return deprecatedFlag{}, false
+}
+
+// Reports whether name is the old name of a renamed flag, which isn't a flag on its own
+func isAlias(name string) bool {
+	d, ok := lookupDeprecated(name)
+	return ok && d.replacement != ""
+}
+
+// Value of a renamed flag set under its old name. The value is applied to the new
+// flag once parsing finishes, so that conflicting values can be detected.
+type aliasValue struct {
+	value string
+	set   bool
+}
+
+func (v *aliasValue) String() string { return v.value }
+
+func (v *aliasValue) Set(s string) error {
+	v.value, v.set = s, true
+	return nil
+}
+
+// Registers old names of renamed flags, hidden from usage
+func registerAliases(fs *flag.FlagSet) {
+	for _, d := range deprecatedFlags {
+		if d.replacement != "" {
+			fs.Var(&aliasValue{}, d.name, fmt.Sprintf("Deprecated, use -%s", d.replacement))
+		}
+	}
+}
+
+// Applies flags set under their old names to the new flags. Fails when both names
+// are set to different values.
+func (c *Config) resolveAliases() error {
+	for _, d := range deprecatedFlags {
+		alias, ok := c.fs.Lookup(d.name).Value.(*aliasValue)
+		if !ok || !alias.set {
+			continue
+		}
+		c.warnDeprecated(d, "-"+d.name)
+		explicit := false
+		c.fs.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == d.replacement
+		})
+		if explicit {
+			if value := c.fs.Lookup(d.replacement).Value.String(); value != alias.value {
+				return fmt.Errorf("-%s=%s conflicts with -%s=%s", d.name, alias.value, d.replacement, value)
+			}
+			continue
+		}
+		if err := c.fs.Set(d.replacement, alias.value); err != nil {
+			return fmt.Errorf("invalid value for -%s: %w", d.name, err)
+		}
+	}
+	return nil
+}
+
+// Returns value and name of the environment variable named after the old name of a renamed flag
+func (c *Config) lookupAliasEnv(name string) (string, string, bool) {
+	for _, d := range deprecatedFlags {
+		if d.replacement != name {
+			continue
+		}
+		if value, ok := os.LookupEnv(envName(d.name)); ok {
+			c.warnDeprecated(d, envName(d.name))
+			return value, envName(d.name), true
+		}
+	}
+	return "", "", false
+}
+
+// Warns about flags scheduled for removal that are set
+func (c *Config) warnRemovedFlags() {
+	for _, d := range deprecatedFlags {
+		if d.replacement == "" && c.Source(d.name) != sourceDefault {
+			c.warnDeprecated(d, "-"+d.name)
+		}
+	}
+}
+
+// Adds a single deprecation warning per flag
+func (c *Config) warnDeprecated(d deprecatedFlag, usedAs string) {
+	if c.deprecated[d.name] {
+		return
+	}
+	if c.deprecated == nil {
+		c.deprecated = map[string]bool{}
+	}
+	c.deprecated[d.name] = true
+	if d.replacement == "" {
+		c.warnings = append(c.warnings, fmt.Sprintf("%s is deprecated and will be removed in a future release", usedAs))
+		return
+	}
+	c.warnings = append(c.warnings, fmt.Sprintf("%s is deprecated, use %s instead", usedAs, newName(d, usedAs)))
+}
+
+// Returns the new name of a renamed flag in the same form (flag, config key or environment variable) it was used
+func newName(d deprecatedFlag, usedAs string) string {
+	switch usedAs {
+	case "-" + d.name:
+		return "-" + d.replacement
+	case envName(d.name):
+		return envName(d.replacement)
+	}
+	return fmt.Sprintf("%q", d.replacement)
+}
+
+// Prints usage of all flags except old names of renamed flags
+func (c *Config) printUsage() {
+	visible := flag.NewFlagSet(c.fs.Name(), flag.ContinueOnError)
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if isAlias(f.Name) {
+			return
+		}
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue
+	})
+	_, _ = fmt.Fprintf(c.fs.Output(), "Usage of %s:\n", c.fs.Name())
+	visible.SetOutput(c.fs.Output())
+	visible.PrintDefaults()
+}
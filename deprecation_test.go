@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDeprecatedFlagAliases(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		env          map[string]string
+		wantRole     string
+		wantRegion   string
+		wantWarnings []string
+	}{
+		{
+			name:         "old flag names",
+			args:         []string{"-rolearn", testRoleARN, "-stsregion", "eu-west-1"},
+			wantRole:     testRoleARN,
+			wantRegion:   "eu-west-1",
+			wantWarnings: []string{"-rolearn is deprecated, use -role-arn instead", "-stsregion is deprecated, use -sts-region instead"},
+		},
+		{
+			name:       "new flag names",
+			args:       []string{"-role-arn", testRoleARN, "-sts-region", "eu-west-1"},
+			wantRole:   testRoleARN,
+			wantRegion: "eu-west-1",
+		},
+		{
+			name:         "same value under both names",
+			args:         []string{"-rolearn", testRoleARN, "-role-arn", testRoleARN},
+			wantRole:     testRoleARN,
+			wantRegion:   defaultSTSRegion,
+			wantWarnings: []string{"-rolearn is deprecated, use -role-arn instead"},
+		},
+		{
+			name:         "old environment variable",
+			env:          map[string]string{envPrefix + "ROLEARN": testRoleARN},
+			wantRole:     testRoleARN,
+			wantRegion:   defaultSTSRegion,
+			wantWarnings: []string{envPrefix + "ROLEARN is deprecated, use " + envPrefix + "ROLE_ARN instead"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			c := loadTestConfig(t, tt.args...)
+			if c.AWSRoleARN != tt.wantRole || c.STSRegion != tt.wantRegion {
+				t.Errorf("role-arn, sts-region = %q, %q, want %q, %q", c.AWSRoleARN, c.STSRegion, tt.wantRole, tt.wantRegion)
+			}
+			if !slices.Equal(c.warnings, tt.wantWarnings) {
+				t.Errorf("warnings = %q, want %q", c.warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestDeprecatedFlagAliasConflicts(t *testing.T) {
+	other := "arn:aws:iam::123456789012:role/other"
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "flags",
+			args:    []string{"-rolearn", testRoleARN, "-role-arn", other},
+			wantErr: "-rolearn=" + testRoleARN + " conflicts with -role-arn=" + other,
+		},
+		{
+			name:    "environment variables",
+			env:     map[string]string{envPrefix + "ROLEARN": testRoleARN, envPrefix + "ROLE_ARN": other},
+			wantErr: envPrefix + "ROLEARN conflicts with " + envPrefix + "ROLE_ARN",
+		},
+		{
+			name:    "config file keys",
+			file:    `{"rolearn": "` + testRoleARN + `", "role-arn": "` + other + `"}`,
+			wantErr: `"rolearn" conflicts with "role-arn"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "-config", path)
+			}
+			_, err := loadTestConfigErr(t, args...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadFromFlags() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrintUsageHidesAliases(t *testing.T) {
+	var out strings.Builder
+	c := loadTestConfig(t)
+	c.fs.SetOutput(&out)
+	c.printUsage()
+	if !strings.Contains(out.String(), "-role-arn") {
+		t.Errorf("usage doesn't list -role-arn:\n%s", out.String())
+	}
+	for _, alias := range []string{"-rolearn ", "-stsregion "} {
+		if strings.Contains(out.String(), alias) {
+			t.Errorf("usage lists deprecated %s:\n%s", alias, out.String())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Runs the whole authentication pipeline including a real STS GetCallerIdentity call
+// with the assumed credentials, and prints human readable summary to w. No credential
+// is emitted. The returned error names the failing stage.
+func dryRun(ctx context.Context, w io.Writer, auth *Authenticator) error {
+	var (
+		sessionIdentifier string
+		token             customIdentityTokenRetriever
+		claims            jwtClaims
+		creds             aws.Credentials
+		identity          *sts.GetCallerIdentityOutput
+	)
+	err := runProbe(ctx, w, []probeStep{
+		{"session identifier", func(ctx context.Context) (detail string, err error) {
+			sessionIdentifier, err = auth.GetSessionIdentifier(ctx)
+			return sessionIdentifier, err
+		}},
+		{"gcp identity token", func(ctx context.Context) (string, error) {
+			var err error
+			if token, err = auth.GetIdentityToken(ctx); err != nil {
+				return "", err
+			}
+			if claims, err = decodeJWTClaims(token.token); err != nil {
+				return "", err
+			}
+			return "audience " + claims.Audience, nil
+		}},
+		{"assume role", func(ctx context.Context) (string, error) {
+			var err error
+			creds, err = auth.GetCredentials(ctx, sessionIdentifier, token)
+			return auth.cfg.AWSRoleARN, err
+		}},
+		{"get caller identity", func(ctx context.Context) (string, error) {
+			var err error
+			identity, err = auth.GetCallerIdentity(ctx, creds)
+			if err != nil {
+				return "", err
+			}
+			return aws.ToString(identity.Arn), nil
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(w, `
+Dry run succeeded, no credential was emitted
+  Assumed role ARN:       %s
+  AWS account:            %s
+  Session name:           %s
+  GCP token audience:     %s
+  GCP token expiration:   %s
+  AWS credentials expire: %s
+`,
+		aws.ToString(identity.Arn),
+		aws.ToString(identity.Account),
+		sessionIdentifier,
+		claims.Audience,
+		claims.ExpiresAt().Format(time.RFC3339),
+		creds.Expires.Format(time.RFC3339),
+	)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Flag value holding a duration in time.ParseDuration syntax (30s, 15m, 1h). Values
+// without a unit, other than 0, are rejected rather than guessed.
+type durationValue struct {
+	d *time.Duration
+}
+
+func (v *durationValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	return v.d.String()
+}
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected e.g. 30s, 15m or 1h", s)
+	}
+	*v.d = d
+	return nil
+}
+
+// Registers duration flag accepting human readable durations
+func durationVar(fs *flag.FlagSet, d *time.Duration, name string, value time.Duration, usage string) {
+	*d = value
+	fs.Var(&durationValue{d: d}, name, usage)
+}
+
+// Accepted range of a duration flag
+type durationRange struct {
+	min, max time.Duration
+}
+
+// Accepted ranges of duration flags keyed by flag name
+var durationRanges = map[string]durationRange{
+	"retry-backoff":        {0, maxRetryBackoff},
+	"http-timeout":         {1 * time.Second, 5 * time.Minute},
+	"sts-timeout":          {1 * time.Second, 10 * time.Minute},
+	"timeout":              {0, 1 * time.Hour},
+	"assume-role-duration": {15 * time.Minute, 12 * time.Hour},
+	"token-expiration":     {2 * time.Minute, 12 * time.Hour},
+}
+
+// Checks duration flags against their accepted ranges
+func (c *Config) validateDurations() []error {
+	names := make([]string, 0, len(durationRanges))
+	for name := range durationRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		v, ok := c.fs.Lookup(name).Value.(*durationValue)
+		if !ok {
+			continue
+		}
+		if r := durationRanges[name]; *v.d < r.min || *v.d > r.max {
+			errs = append(errs, &ValidationError{Flag: name, Err: fmt.Errorf("%s is out of range, must be between %s and %s", v.d, r.min, r.max)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/smithy-go"
+)
+
+// Sentinel errors wrapped by failures of the individual steps, to be matched with errors.Is
+var (
+	// GCP metadata server couldn't be reached or responded with a transient error
+	ErrMetadataUnavailable = errors.New("GCP metadata server unavailable")
+	// GCP identity token couldn't be retrieved or didn't pass the checks
+	ErrTokenRetrieval = errors.New("GCP identity token retrieval failed")
+	// STS AssumeRoleWithWebIdentity failed
+	ErrAssumeRole = errors.New("AWS assume role failed")
+	// Presigning the EKS token failed
+	ErrPresign = errors.New("presigning EKS token failed")
+	// Configuration is invalid, matched by every [ValidationError]
+	ErrValidation = errors.New("invalid configuration")
+)
+
+// Marks a failed request to the GCP metadata server as [ErrMetadataUnavailable] when
+// the server couldn't be reached or responded with a transient error
+func markMetadataUnavailable(err error) error {
+	if err != nil && isRetryableError(err) {
+		return fmt.Errorf("%w: %w", ErrMetadataUnavailable, err)
+	}
+	return err
+}
+
+// Failure of a step of issuing a credential. It matches the sentinel of the step,
+// [ErrTokenRetrieval], [ErrAssumeRole] or [ErrPresign], with errors.Is.
+type AuthError struct {
+	Kind    error
+	RoleARN string
+	Region  string
+	Code    string // Error code of a failed AWS API call, e.g. InvalidIdentityToken
+	Err     error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+func (e *AuthError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// Wraps err of given step in [AuthError] carrying the role ARN and region
+func (a *Authenticator) authError(kind error, region string, err error) error {
+	authErr := &AuthError{Kind: kind, RoleARN: a.cfg.AWSRoleARN, Region: region, Err: err}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		authErr.Code = apiErr.ErrorCode()
+	}
+	return authErr
+}
+
+// Exit codes of failed steps, 1 is used for all other failures
+const (
+	exitTokenRetrieval = 4
+	exitAssumeRole     = 5
+	exitPresign        = 6
+)
+
+// Returns exit code for a failed step
+func authExitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrTokenRetrieval):
+		return exitTokenRetrieval
+	case errors.Is(err, ErrAssumeRole):
+		return exitAssumeRole
+	case errors.Is(err, ErrPresign):
+		return exitPresign
+	}
+	return 1
+}
+
+// Returns hint on fixing a failed AWS API call, empty when there's none
+func authErrorHint(err error) string {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		return ""
+	}
+	switch authErr.Code {
+	case "InvalidIdentityToken":
+		return "STS rejected the identity token, check that -audience matches the role trust policy or IAM OIDC provider"
+	case "ExpiredToken", "ExpiredTokenException":
+		return "the identity token expired before STS validated it, check the clock of this machine"
+	case "AccessDenied":
+		return "the role trust policy doesn't allow this identity, check its conditions and -role-arn"
+	case "IDPCommunicationError":
+		return "STS couldn't reach the identity provider, retry later"
+	}
+	return ""
+}
+
+// Returns log attributes describing err: the role ARN, region, error code and hint of an [AuthError]
+func authErrorAttrs(err error) []any {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		return nil
+	}
+	attrs := []any{"roleArn", authErr.RoleARN, "region", authErr.Region}
+	if authErr.Code != "" {
+		attrs = append(attrs, "code", authErr.Code)
+	}
+	if hint := authErrorHint(err); hint != "" {
+		attrs = append(attrs, "hint", hint)
+	}
+	return attrs
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestAuthErrorExitCodes(t *testing.T) {
+	captureLogs(t)
+	auth := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-sts-region", "eu-west-1"))
+	invalidToken := &smithy.GenericAPIError{Code: "InvalidIdentityToken", Message: "audience mismatch"}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		want     int
+		wantKind error
+		wantCode string
+	}{
+		{"token retrieval", context.Background(), auth.authError(ErrTokenRetrieval, "eu-west-1", errors.New("empty token file")), exitTokenRetrieval, ErrTokenRetrieval, ""},
+		{"metadata unavailable", context.Background(), auth.authError(ErrTokenRetrieval, "eu-west-1", markMetadataUnavailable(&httpStatusError{statusCode: 503})), exitTokenRetrieval, ErrTokenRetrieval, ""},
+		{"assume role", context.Background(), auth.authError(ErrAssumeRole, "eu-west-1", fmt.Errorf("operation error STS: %w", invalidToken)), exitAssumeRole, ErrAssumeRole, "InvalidIdentityToken"},
+		{"presign", context.Background(), auth.authError(ErrPresign, "eu-central-1", errors.New("presigned URL failed validation")), exitPresign, ErrPresign, ""},
+		{"canceled", canceled, auth.authError(ErrAssumeRole, "eu-west-1", context.Canceled), exitCanceled, ErrAssumeRole, ""},
+		{"other failure", context.Background(), errors.New("couldn't write output file"), 1, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.ctx, authExitCode(tt.err)); got != tt.want {
+				t.Errorf("exit code = %d, want %d", got, tt.want)
+			}
+			var authErr *AuthError
+			if !errors.As(tt.err, &authErr) {
+				if tt.wantKind != nil {
+					t.Fatalf("%v isn't an AuthError", tt.err)
+				}
+				return
+			}
+			if !errors.Is(tt.err, tt.wantKind) || authErr.RoleARN != testRoleARN || authErr.Region == "" || authErr.Code != tt.wantCode {
+				t.Errorf("AuthError = %+v, want kind %v, role %s and code %q", authErr, tt.wantKind, testRoleARN, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Repeatable flag value collecting key=value HTTP headers
+type headersValue map[string]string
+
+func (v headersValue) String() string {
+	pairs := make([]string, 0, len(v))
+	for key, value := range v {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v headersValue) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("invalid header %q, expected key=value", s)
+	}
+	key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+	if !httpguts.ValidHeaderFieldName(key) {
+		return fmt.Errorf("invalid header name %q", key)
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("invalid value of header %s", key)
+	}
+	// Required headers of the EKS token can't be overridden
+	if strings.EqualFold(key, eksClusterIdHeader) || strings.HasPrefix(key, "X-Amz-") {
+		return fmt.Errorf("header %s is set by the presigner and can't be overridden", key)
+	}
+	v[key] = value
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// Hint for wrapping tooling on whether and when to retry a failed STS call
+type retryHint struct {
+	Retryable  bool    // Whether retrying the same request may succeed
+	RetryAfter float64 // Suggested delay in seconds before the next attempt
+	Reason     string  // Short machine readable failure category
+}
+
+// Builds retry hint for an STS failure. Retryable failures suggest the delay that would
+// follow the retries already made by the policy.
+func newRetryHint(err error, policy retryPolicy) retryHint {
+	var reason string
+	switch {
+	case errors.Is(err, errSTSTimeout):
+		reason = "timeout"
+	case isExpiredTokenError(err):
+		reason = "expired_token"
+	case retry.IsErrorRetryables(stsRetryables).IsErrorRetryable(err) == aws.TrueTernary:
+		reason = "transient"
+	default:
+		return retryHint{Retryable: false, Reason: "permanent"}
+	}
+	return retryHint{
+		Retryable:  true,
+		RetryAfter: policy.delay(policy.maxRetries + 1).Seconds(),
+		Reason:     reason,
+	}
+}
+
+// Returns the hint as a log attribute
+func (h retryHint) attr() slog.Attr {
+	return slog.Group("retry_hint", "retryable", h.Retryable, "retry_after", h.RetryAfter, "reason", h.Reason)
+}
+
+// Logs failure to retrieve AWS credentials, with a retry hint when withHint is set
+func logCredentialsError(err error, withHint bool, policy retryPolicy) {
+	attrs := append([]any{"error", err}, authErrorAttrs(err)...)
+	if withHint {
+		attrs = append(attrs, newRetryHint(err, policy).attr())
+	}
+	logger.Error("Couldn't retrieve AWS credentials", attrs...)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestRetryHintOnSTSFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		failure       stsFailure
+		hint          bool
+		wantRetryable bool
+		wantReason    string
+	}{
+		{"access denied", stsFailure{http.StatusForbidden, "AccessDenied"}, true, false, "permanent"},
+		{"unavailable", stsFailure{http.StatusServiceUnavailable, "ServiceUnavailable"}, true, true, "transient"},
+		{"throttled", stsFailure{http.StatusBadRequest, "Throttling"}, true, true, "transient"},
+		{"expired token", stsFailure{http.StatusBadRequest, "ExpiredTokenException"}, true, true, "expired_token"},
+		{"without -retry-hint", stsFailure{http.StatusServiceUnavailable, "ServiceUnavailable"}, false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+			srv := newFakeSTSServer(t, tt.failure)
+			logs := captureLogs(t)
+			cfg := loadTestConfig(t, "-role-arn", "arn:aws:iam::123456789012:role/test", "-cluster", "test",
+				"-aws-endpoint", srv.URL, "-max-retries", "0", "-retry-expired-token=false")
+			auth := NewAuthenticator(cfg)
+
+			_, err := auth.GetCredentials(context.Background(), "session", customIdentityTokenRetriever{token: []byte("token")})
+			if err == nil {
+				t.Fatal("GetCredentials() succeeded")
+			}
+			logCredentialsError(err, tt.hint, auth.policy)
+
+			entries := logEntries(t, logs.String(), "Couldn't retrieve AWS credentials")
+			if len(entries) != 1 || entries[0]["level"] != "ERROR" || entries[0]["error"] == nil {
+				t.Fatalf("log entries = %v, want one error entry", entries)
+			}
+			hint, ok := entries[0]["retry_hint"].(map[string]any)
+			if !tt.hint {
+				if ok {
+					t.Errorf("retry_hint = %v without -retry-hint", hint)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("log entry %v has no retry_hint", entries[0])
+			}
+			if hint["retryable"] != tt.wantRetryable || hint["reason"] != tt.wantReason {
+				t.Errorf("retry_hint = %v, want retryable %v and reason %q", hint, tt.wantRetryable, tt.wantReason)
+			}
+			if after, _ := hint["retry_after"].(float64); tt.wantRetryable != (after > 0) {
+				t.Errorf("retry_hint retry_after = %v, want positive %v", hint["retry_after"], tt.wantRetryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Hosts of the GCP metadata server, which must never be reached through a proxy
+var metadataHosts = []string{"metadata.google.internal", "169.254.169.254"}
+
+// Environment variable overriding GCP metadata server host, as in the GCP client libraries
+const metadataHostEnv = "GCE_METADATA_HOST"
+
+// Returns host[:port] of the GCP metadata server, -gcp-metadata-host taking precedence
+// over GCE_METADATA_HOST. Empty when neither is set and the default hosts are used.
+func (c *Config) metadataHost() string {
+	if c.MetadataHost != "" {
+		return c.MetadataHost
+	}
+	return os.Getenv(metadataHostEnv)
+}
+
+// Transport sending requests for the default GCP metadata server hosts or the one in
+// GCE_METADATA_HOST to host instead, so that every metadata request honors
+// -gcp-metadata-host without changing the process environment
+type metadataHostTransport struct {
+	host string
+	next http.RoundTripper
+}
+
+func (t *metadataHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host || !slices.Contains(metadataHosts, req.URL.Host) && req.URL.Host != os.Getenv(metadataHostEnv) {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.URL.Host = t.host
+	req.Host = t.host
+	return t.next.RoundTrip(req)
+}
+
+// Creates proxy function for outbound HTTP requests. The proxy is taken from the
+// standard HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, with proxyURL
+// overriding HTTP_PROXY and HTTPS_PROXY when set. GCP metadata server is always
+// excluded from proxying.
+func newProxyFunc(cfg *Config) func(*http.Request) (*url.URL, error) {
+	proxyCfg := httpproxy.FromEnvironment()
+	if cfg.ProxyURL != "" {
+		proxyCfg.HTTPProxy = cfg.ProxyURL
+		proxyCfg.HTTPSProxy = cfg.ProxyURL
+	}
+	noProxy := append([]string{}, metadataHosts...)
+	if host := cfg.metadataHost(); host != "" {
+		noProxy = append(noProxy, host)
+	}
+	if proxyCfg.NoProxy != "" {
+		noProxy = append(noProxy, proxyCfg.NoProxy)
+	}
+	proxyCfg.NoProxy = strings.Join(noProxy, ",")
+
+	proxyFunc := proxyCfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// Creates HTTP client for GCP metadata server requests, sending them to the host
+// configured with -gcp-metadata-host or GCE_METADATA_HOST and logging each request at
+// debug level
+func newMetadataHTTPClient(cfg *Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = newProxyFunc(cfg)
+	trustCABundle(transport, cfg.CABundle)
+	var next http.RoundTripper = transport
+	if host := cfg.metadataHost(); host != "" {
+		next = &metadataHostTransport{host: host, next: transport}
+	}
+	return &http.Client{Timeout: cfg.HTTPTimeout, Transport: timingTransport{next: next}}
+}
+
+// Connection timeouts of AWS STS requests, failing fast on black-holed egress paths
+const (
+	awsDialTimeout         = 5 * time.Second
+	awsTLSHandshakeTimeout = 5 * time.Second
+)
+
+// Creates HTTP client shared by all AWS STS clients, keeping the SDK defaults apart from
+// the proxy and -sts-vpc-endpoint. Connections are reused, establishing them is bounded
+// by short dial and TLS handshake timeouts and every request by the STS timeout.
+func newAWSHTTPClient(cfg *Config) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().
+		WithTimeout(cfg.STSTimeout).
+		WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = awsDialTimeout
+		}).
+		WithTransportOptions(func(t *http.Transport) {
+			t.TLSHandshakeTimeout = awsTLSHandshakeTimeout
+			t.Proxy = newProxyFunc(cfg)
+			trustCABundle(t, cfg.CABundle)
+			if cfg.STSVPCEndpoint != "" {
+				dialSTSVPCEndpoint(t, stsRegionalHost(cfg.partition(), cfg.STSRegion), cfg.STSVPCEndpoint)
+			}
+		})
+}
+
+// Makes t dial the STS VPC endpoint (PrivateLink) for connections to the regional STS
+// host, without a proxy, so that requests keep being signed for the regional host and
+// TLS is verified against it
+func dialSTSVPCEndpoint(t *http.Transport, regionalHost, vpcEndpoint string) {
+	t.Proxy = nil
+	regionalHost = net.JoinHostPort(regionalHost, "443")
+	if _, _, err := net.SplitHostPort(vpcEndpoint); err != nil {
+		vpcEndpoint = net.JoinHostPort(vpcEndpoint, "443")
+	}
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == regionalHost {
+			addr = vpcEndpoint
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// Loads PEM bundle of CA certificates into a pool with the system CA certificates
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// Makes the transport trust CA certificates of the bundle in addition to the system ones
+func trustCABundle(t *http.Transport, caBundle string) {
+	if caBundle == "" {
+		return
+	}
+	// The bundle was loaded successfully while validating configuration
+	pool, _ := loadCABundle(caBundle)
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	} else {
+		t.TLSClientConfig = t.TLSClientConfig.Clone()
+	}
+	t.TLSClientConfig.RootCAs = pool
+}
+
+// Returns host of the regional STS endpoint in given partition
+func stsRegionalHost(partition string, region string) string {
+	return "sts." + region + "." + awsPartitions[partition]
+}
+
+// Validates a bare host with optional port
+func validateHostPort(host string) error {
+	u, err := url.Parse("http://" + host)
+	if err != nil || u.Host != host || host == "" {
+		return fmt.Errorf("%q must be a host with optional port", host)
+	}
+	return nil
+}
+
+// Validates proxy URL
+func validateProxyURL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy URL %q has no host", proxyURL)
+	}
+	return nil
+}
+
+// Validates STS endpoint override, which must be an absolute http(s) URL without query or fragment
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("endpoint %q must start with https:// or http://", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("endpoint %q has no host", endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("endpoint %q can't contain query or fragment", endpoint)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Forward proxy answering every request as the fake STS endpoint and recording the
+// requested URLs
+type recordingProxy struct {
+	*httptest.Server
+	mu   sync.Mutex
+	urls []string
+}
+
+func newRecordingProxy(t *testing.T) *recordingProxy {
+	t.Helper()
+	p := &recordingProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.urls = append(p.urls, r.URL.String())
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, fakeSTSResponses["GetCallerIdentity"])
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Returns URLs requested through the proxy so far
+func (p *recordingProxy) URLs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.urls)
+}
+
+// Clears proxy environment variables so that only -proxy-url applies
+func clearProxyEnv(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestProxyURLRoutesSTSThroughProxy(t *testing.T) {
+	clearProxyEnv(t)
+	proxy := newRecordingProxy(t)
+	cfg := &Config{ProxyURL: proxy.URL, HTTPTimeout: 5 * time.Second}
+
+	client := sts.New(sts.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String("http://sts.us-east-1.amazonaws.com"),
+		HTTPClient:   newAWSHTTPClient(cfg),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	out, err := client.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		t.Fatalf("GetCallerIdentity() through proxy: %v", err)
+	}
+	if aws.ToString(out.Account) != "123456789012" {
+		t.Errorf("account = %q, want the proxy's response", aws.ToString(out.Account))
+	}
+	if got, want := proxy.URLs(), []string{"http://sts.us-east-1.amazonaws.com/"}; !slices.Equal(got, want) {
+		t.Errorf("proxied URLs = %q, want %q", got, want)
+	}
+}
+
+func TestProxyURLBypassesMetadataServer(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          string // GCE_METADATA_HOST
+		metadataHost string
+		want         []string // Dialed addresses, connections are reused per address
+	}{
+		{"default hosts", "", "", []string{"metadata.google.internal:80", "169.254.169.254:80"}},
+		{"GCE_METADATA_HOST", "metadata.test:8080", "", []string{"metadata.test:8080"}},
+		{"gcp-metadata-host", "metadata.test:8080", "metadata-proxy:8080", []string{"metadata-proxy:8080"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			t.Setenv("GCE_METADATA_HOST", tt.env)
+			proxy := newRecordingProxy(t)
+			md := newFakeMetadataServer(t, map[string]string{"project/project-id": "test-project"})
+			cfg := &Config{ProxyURL: proxy.URL, HTTPTimeout: 5 * time.Second, MetadataHost: tt.metadataHost}
+
+			// Every direct connection ends up at the fake metadata server
+			client := newMetadataHTTPClient(cfg)
+			next := client.Transport.(timingTransport).next
+			transport, ok := next.(*http.Transport)
+			if !ok {
+				transport = next.(*metadataHostTransport).next.(*http.Transport)
+			}
+			var mu sync.Mutex
+			var dialed []string
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				mu.Lock()
+				dialed = append(dialed, addr)
+				mu.Unlock()
+				return (&net.Dialer{}).DialContext(ctx, network, md.host())
+			}
+
+			hosts := []string{"metadata.google.internal", "169.254.169.254"}
+			if tt.env != "" {
+				hosts = append(hosts, tt.env)
+			}
+			for _, host := range hosts {
+				req, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/project/project-id", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Metadata-Flavor", "Google")
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("GET %s: %v", req.URL, err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if string(body) != "test-project" {
+					t.Errorf("GET %s = %q, want the metadata server's response", req.URL, body)
+				}
+			}
+			if urls := proxy.URLs(); len(urls) != 0 {
+				t.Errorf("metadata requests went through the proxy: %q", urls)
+			}
+			if !slices.Equal(dialed, tt.want) {
+				t.Errorf("dialed %q, want %q", dialed, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		valid    bool
+	}{
+		{"https://sts.eu-west-1.amazonaws.com", true},
+		{"https://sts.eu-west-1.amazonaws.com/", true},
+		{"http://localhost:4566", true},
+		{"https://vpce-0abc.sts.eu-west-1.vpce.amazonaws.com/sts", true},
+		{"sts.eu-west-1.amazonaws.com", false},
+		{"//sts.eu-west-1.amazonaws.com", false},
+		{"ftp://sts.eu-west-1.amazonaws.com", false},
+		{"https://", false},
+		{"https:///path", false},
+		{"https://sts.eu-west-1.amazonaws.com?Action=GetCallerIdentity", false},
+		{"https://sts.eu-west-1.amazonaws.com#fragment", false},
+		{"https://[::1", false},
+		{"https://sts.eu-west-1.amazonaws.com:port", false},
+		{"://sts.eu-west-1.amazonaws.com", false},
+	}
+	for _, tt := range tests {
+		if err := validateEndpointURL(tt.endpoint); (err == nil) != tt.valid {
+			t.Errorf("validateEndpointURL(%q) error = %v, want valid %v", tt.endpoint, err, tt.valid)
+		}
+	}
+}
+
+func TestSTSVPCEndpointDialsVPCEndpoint(t *testing.T) {
+	var host, authorization string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, authorization = r.Host, r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, fakeSTSResponses["GetCallerIdentity"])
+	}))
+	t.Cleanup(srv.Close)
+
+	// The VPC endpoint resolves to the fake server, whose certificate is issued for example.com
+	const vpcEndpoint = "vpce-0abc123-xyz.sts.eu-west-1.vpce.amazonaws.com"
+	var dialed []string
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.ServerName = "example.com"
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	dialSTSVPCEndpoint(transport, stsRegionalHost("aws", "eu-west-1"), vpcEndpoint)
+
+	client := sts.New(sts.Options{
+		Region:      "eu-west-1",
+		HTTPClient:  &http.Client{Transport: transport},
+		Credentials: credentials.NewStaticCredentialsProvider("AKIAFAKEACCESSKEY000", "fake-secret", ""),
+	})
+	if _, err := client.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{}); err != nil {
+		t.Fatalf("GetCallerIdentity() through VPC endpoint: %v", err)
+	}
+	if want := []string{vpcEndpoint + ":443"}; !slices.Equal(dialed, want) {
+		t.Errorf("dialed %q, want %q", dialed, want)
+	}
+	if host != "sts.eu-west-1.amazonaws.com" {
+		t.Errorf("Host = %q, want the regional STS host", host)
+	}
+	if !strings.Contains(authorization, "/eu-west-1/sts/aws4_request") || !strings.Contains(authorization, ";host;") {
+		t.Errorf("Authorization = %q, want host signed for eu-west-1", authorization)
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	captureLogs(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		wantFail bool
+	}{
+		{"system CAs", nil, true},
+		{"bundle", []string{"-ca-bundle", bundle}, false},
+	} {
+		cfg := loadTestConfig(t, append([]string{"-role-arn", testRoleARN, "-cluster", "test"}, tt.args...)...)
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+		clients := map[string]interface {
+			Do(*http.Request) (*http.Response, error)
+		}{"metadata": newMetadataHTTPClient(cfg), "aws": newAWSHTTPClient(cfg)}
+		for client, c := range clients {
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantFail {
+				t.Errorf("%s: %s client error = %v, want failure %v", tt.name, client, err, tt.wantFail)
+			}
+		}
+	}
+
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-ca-bundle", filepath.Join(t.TempDir(), "missing.pem"))
+	if problems := ValidationErrors(cfg.validate()); len(problems) != 1 || problems[0].Flag != "ca-bundle" {
+		t.Errorf("validate() of a missing bundle = %v, want a ca-bundle problem", problems)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims of GCP identity token relevant to AWS web identity federation
+type jwtClaims struct {
+	Audience string `json:"aud"`
+	Subject  string `json:"sub"`
+	Email    string `json:"email"`
+	Expiry   int64  `json:"exp"`
+}
+
+// Returns token expiration time
+func (c jwtClaims) ExpiresAt() time.Time {
+	return time.Unix(c.Expiry, 0)
+}
+
+// Decodes claims from the payload of a JWT. The signature is not verified,
+// the claims are only used for diagnostics.
+func decodeJWTClaims(token []byte) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 3 {
+		return claims, errors.New("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("base64 decode of JWT payload: %w", err)
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("json.Unmarshal of JWT payload: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Configures the package logger according to the configuration, discarding all logs with -quiet
+func setupLogger(cfg *Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("-log-level: %w", err)
+	}
+
+	var w io.Writer = os.Stderr
+	if cfg.Quiet {
+		w = io.Discard
+	} else if cfg.LogFile != "" {
+		// With O_APPEND every write lands atomically at the end of the file, and the slog
+		// handler writes each record as a single complete line. Multiple plugin processes
+		// logging to the same file therefore never split each other's JSON entries, unless
+		// the file is on NFS, which doesn't support atomic appends.
+		f, err := os.OpenFile(cfg.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("os.OpenFile: %w", err)
+		}
+		w = f
+	}
+	logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLogFileConcurrentWriters(t *testing.T) {
+	const writers, records = 8, 200
+	cfg := &Config{LogLevel: "info", LogFile: filepath.Join(t.TempDir(), "plugin.log")}
+
+	// Every logger has its own file descriptor, like separate plugin processes
+	prev := logger
+	t.Cleanup(func() { logger = prev })
+	var loggers []*slog.Logger
+	for i := 0; i < writers; i++ {
+		if err := setupLogger(cfg); err != nil {
+			t.Fatal(err)
+		}
+		loggers = append(loggers, logger)
+	}
+
+	payload := strings.Repeat("x", 8192)
+	var wg sync.WaitGroup
+	for i, l := range loggers {
+		wg.Add(1)
+		go func(writer int, l *slog.Logger) {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				l.Info("Concurrent write", "writer", writer, "record", j, "payload", payload)
+			}
+		}(i, l)
+	}
+	wg.Wait()
+
+	f, err := os.Open(cfg.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		if !json.Valid(scanner.Bytes()) {
+			t.Fatalf("line %d isn't valid JSON: %.100s", lines, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if lines != writers*records {
+		t.Errorf("log file has %d lines, want %d", lines, writers*records)
+	}
+}
+
+// Redirects stdout and stderr to files until the end of the test, returning their paths
+func redirectStdio(t *testing.T) (stdout, stderr string) {
+	t.Helper()
+	redirect := func(target **os.File, name string) string {
+		path := filepath.Join(t.TempDir(), name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev := *target
+		*target = f
+		t.Cleanup(func() {
+			*target = prev
+			f.Close()
+		})
+		return path
+	}
+	return redirect(&os.Stdout, "stdout"), redirect(&os.Stderr, "stderr")
+}
+
+func TestQuietWritesOnlyCredential(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		output     string
+		wantCode   int
+		wantStdout bool // ExecCredential written to stdout instead of the output file
+	}{
+		{"stdout", "", 0, true},
+		{"output file", filepath.Join(dir, "credential.json"), 0, false},
+		{"failure", filepath.Join(dir, "missing", "credential.json"), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := redirectStdio(t)
+			args := []string{"-role-arn", testRoleARN, "-cluster", "test", "-mock", "-quiet", "-log-level", "debug"}
+			if tt.output != "" {
+				args = append(args, "-output", tt.output)
+			}
+			cfg := loadTestConfig(t, args...)
+			prev := logger
+			t.Cleanup(func() { logger = prev })
+			if err := setupLogger(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if code := run(context.Background(), cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1); code != tt.wantCode {
+				t.Errorf("run() = %d, want %d", code, tt.wantCode)
+			}
+
+			if logs, _ := os.ReadFile(stderr); len(logs) != 0 {
+				t.Errorf("stderr = %q, want nothing with -quiet", logs)
+			}
+			out, _ := os.ReadFile(stdout)
+			if got := strings.Contains(string(out), `"kind":"ExecCredential"`); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want ExecCredential %t", out, tt.wantStdout)
+			}
+			if !tt.wantStdout && len(out) != 0 {
+				t.Errorf("stdout = %q, want nothing with -quiet and -output", out)
+			}
+			if tt.output != "" && tt.wantCode == 0 {
+				if credential, err := os.ReadFile(tt.output); err != nil || !strings.Contains(string(credential), `"kind":"ExecCredential"`) {
+					t.Errorf("output file = %q, %v, want ExecCredential", credential, err)
+				}
+			}
+		})
+	}
+}
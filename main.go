@@ -2,24 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
-	"cloud.google.com/go/compute/metadata"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
 )
 
@@ -30,53 +37,214 @@ const (
 	// server side in 0.3.0 or earlier).  IT IS IGNORED.  If we can get STS to support x-amz-expires, then we should
 	// set this parameter to the actual expiration, and make it configurable.
 	requestPresignParam    = 60
+	maxPresignExpires      = 900              // Maximum X-Amz-Expires of presigned STS URLs in seconds
 	presignedURLExpiration = 15 * time.Minute // The actual token expiration (presigned STS urls are valid for 15 minutes after timestamp in x-amz-date).
-	tokenV1Prefix          = "k8s-aws-v1."    // Prefix of a token in client.authentication.k8s.io/v1beta1 ExecCredential
+	tokenV1Prefix          = "k8s-aws-v1."    // Prefix of a token in client.authentication.k8s.io ExecCredential
+	minSessionNameLength   = 2                // Minimum length of AWS role session names accepted by STS
+	maxSessionNameLength   = 64               // Maximum length of AWS role session names accepted by STS
+	sessionNameHashLength  = 8                // Length of the hash suffix keeping truncated session names unique
+
+	exitCanceled = 3 // Exit code when interrupted by a signal or -timeout
+
+	execCredentialV1      = "client.authentication.k8s.io/v1"
+	execCredentialV1beta1 = "client.authentication.k8s.io/v1beta1"
 )
 
-var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+// Logs go to stderr so that they never interleave with the ExecCredential written to stdout
+var logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
 
-// Creates GCP metadata client
-func gcpMetadataClient() *metadata.Client {
-	c := metadata.NewClient(&http.Client{Timeout: 1 * time.Second})
-	return c
+// Capabilities compiled into this build. Printed by the -features flag so that
+// wrappers can detect what a given binary supports before invoking it.
+//
+// Every alternative run mode, identity token or AWS credential source, output format
+// or destination, EKS token option, STS or metadata endpoint and transport option and
+// telemetry exporter adds an entry here when it lands. Tuning flags (timeouts, retries,
+// durations, logging, session naming, identity token claims and extra checks) don't.
+var features = []string{
+	"exec-credential-v1",
+	"exec-credential-v1beta1",
+	"gcp-metadata-identity-token",
+	"web-identity-federation",
+	"output-file",
+	"config-file",
+	"proxy-url",
+	"probe-metadata",
+	"dry-run",
+	"validate-config",
+	"cluster-region",
+	"aws-endpoint",
+	"sts-region-auto",
+	"print-effective-config",
+	"serve",
+	"otlp-tracing",
+	"selftest",
+	"presign-header",
+	"gcp-metadata-host",
+	"sts-vpc-endpoint",
+	"mock",
+	"token-file",
+	"token-stdin",
+	"output-format-aws-credential-process",
+	"token-format-eks",
+	"output-format-env",
+	"output-format-json",
+	"credential-source-ambient",
+	"credential-source-profile",
+	"audit-log",
+	"partition",
+	"signing-region",
+	"ca-bundle",
 }
 
-// Constucts AWs session identifier from GCP metadata infrmation.
-// This implementation uses concentration of  GCP project ID and machine hostname
-func createSessionIdentifier(c *metadata.Client) (string, error) {
-	projectId, err := c.ProjectID()
+// Writes the capabilities of this build as a single JSON line
+func writeFeatures(w io.Writer) error {
+	enc, err := json.Marshal(features)
 	if err != nil {
-		logger.Error("Couldn't fetch ProjectId from GCP metadata server")
-		return "", err
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(enc))
+	return err
+}
+
+// Base URL of GCP metadata server paths. The metadata HTTP client directs requests to
+// -gcp-metadata-host or GCE_METADATA_HOST when set.
+const metadataBaseURL = "http://metadata.google.internal/computeMetadata/v1/"
+
+// Fetches GCP metadata value at path, e.g. project/project-id, canceling the request
+// with ctx
+func gcpMetadataValue(ctx context.Context, httpClient *http.Client, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataBaseURL+path, nil)
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("metadata %s: %w", path, &httpStatusError{statusCode: resp.StatusCode})
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("io.ReadAll: %w", err)
 	}
+	return strings.TrimSpace(string(b)), nil
+}
 
-	hostname, err := c.Hostname()
+// Constucts AWs session identifier from GCP metadata infrmation.
+// This implementation expands the session name format, by default concentration of GCP
+// project ID and machine hostname, or uses a stable hash of it when hashed is set so
+// that neither leaks into CloudTrail.
+func createSessionIdentifier(ctx context.Context, httpClient *http.Client, format string, hashed bool) (string, error) {
+	var err error
+	identifier := sessionNamePlaceholder.ReplaceAllStringFunc(format, func(placeholder string) string {
+		if err != nil {
+			return ""
+		}
+		var value string
+		switch placeholder {
+		case "{project}":
+			if value, err = gcpMetadataValue(ctx, httpClient, "project/project-id"); err != nil {
+				logger.Error("Couldn't fetch ProjectId from GCP metadata server")
+			}
+		case "{hostname}":
+			if value, err = gcpMetadataValue(ctx, httpClient, "instance/hostname"); err != nil {
+				logger.Error("Couldn't fetch Hostname from GCP metadata server")
+			}
+		}
+		return value
+	})
 	if err != nil {
-		logger.Error("Couldn't fetch Hostname from GCP metadata server")
 		return "", err
 	}
 
-	return (fmt.Sprintf("%s-%s", projectId, hostname)[:32]), nil
+	if hashed {
+		sum := sha256.Sum256([]byte(identifier))
+		return hex.EncodeToString(sum[:])[:16], nil
+	}
+	return normalizeSessionIdentifier(identifier)
+}
+
+// Turns identifier into a role session name STS accepts, matching [\w+=,.@-]{2,64}.
+// Identifiers that are too short even before sanitizing, e.g. because of an empty
+// hostname, are rejected rather than padded into a meaningless session name.
+func normalizeSessionIdentifier(identifier string) (string, error) {
+	identifier = shortenSessionIdentifier(sanitizeSessionIdentifier(identifier))
+	if len(identifier) < minSessionNameLength {
+		return "", fmt.Errorf("session identifier %q is shorter than the %d characters STS requires, set -session-name", identifier, minSessionNameLength)
+	}
+	return identifier, nil
+}
+
+// Placeholders of the session name format
+var sessionNamePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Checks that the session name format references only known placeholders
+func validateSessionNameFormat(format string) error {
+	placeholders := sessionNamePlaceholder.FindAllString(format, -1)
+	if len(placeholders) == 0 {
+		return errors.New("format must reference {project} or {hostname}")
+	}
+	for _, placeholder := range placeholders {
+		if placeholder != "{project}" && placeholder != "{hostname}" {
+			return fmt.Errorf("unknown placeholder %s, expected {project} or {hostname}", placeholder)
+		}
+	}
+	return nil
+}
+
+// Characters not allowed in AWS role session names
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// Replaces characters STS doesn't accept in role session names with dashes
+func sanitizeSessionIdentifier(identifier string) string {
+	return invalidSessionNameChars.ReplaceAllString(identifier, "-")
+}
+
+// Shortens identifier to the session name length limit. Long identifiers keep a readable
+// prefix followed by a short hash of the full identifier, so that instances whose names
+// only differ past the limit don't collide on the same session name.
+func shortenSessionIdentifier(identifier string) string {
+	if len(identifier) <= maxSessionNameLength {
+		return identifier
+	}
+	sum := sha256.Sum256([]byte(identifier))
+	prefix := identifier[:maxSessionNameLength-sessionNameHashLength-1]
+	return prefix + "-" + hex.EncodeToString(sum[:])[:sessionNameHashLength]
+}
+
+// Retrieves GCE identity token using [gcpRetrieveGCEVMToken], retrying transient failures
+// according to the retry policy.
+func gcpRetrieveGCEVMTokenWithRetry(ctx context.Context, httpClient *http.Client, audience string, format string, policy retryPolicy) (customIdentityTokenRetriever, error) {
+	var token customIdentityTokenRetriever
+	err := policy.do(ctx, "gcp.identity_token", func(ctx context.Context) error {
+		var err error
+		token, err = gcpRetrieveGCEVMToken(ctx, httpClient, audience, format)
+		return err
+	})
+	return token, err
 }
 
 // Retrieves GCE identity token (JWT) and retuens [customIdentityTokenRetriever] instance
 // containing the token. This is to be then used in [stscreds.NewWebIdentityRoleProvider]
 // function.
-func gcpRetrieveGCEVMToken(ctx context.Context) (customIdentityTokenRetriever, error) {
-	url := "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity?format=full&audience=gcp"
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func gcpRetrieveGCEVMToken(ctx context.Context, httpClient *http.Client, audience string, format string) (customIdentityTokenRetriever, error) {
+	tokenURL := metadataBaseURL + "instance/service-accounts/default/identity?" + identityTokenQuery(audience, format).Encode()
+	logger.Debug("Requesting GCP identity token", "audience", audience, "format", format)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
 	if err != nil {
 		return customIdentityTokenRetriever{token: nil}, fmt.Errorf("http.NewRequest: %w", err)
 	}
 	req.Header.Set("Metadata-Flavor", "Google")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return customIdentityTokenRetriever{token: nil}, fmt.Errorf("client.Do: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return customIdentityTokenRetriever{token: nil}, fmt.Errorf("status code %d", resp.StatusCode)
+		return customIdentityTokenRetriever{token: nil}, &httpStatusError{statusCode: resp.StatusCode}
 	}
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -86,93 +254,428 @@ func gcpRetrieveGCEVMToken(ctx context.Context) (customIdentityTokenRetriever, e
 	return gcpMetadataToken, nil
 }
 
-func main() {
-	awsAssumeRoleArn := flag.String("rolearn", "", "AWS role ARN to assume (required)")
-	eksClusterName := flag.String("cluster", "", "AWS cluster name for which we create credentials (required)")
-	stsRegion := flag.String("stsregion", "us-east-1", "AWS STS region to which requests are made (optional)")
+// Builds metadata server identity endpoint query. The full format includes instance
+// details in the token, license codes are only requested with it.
+func identityTokenQuery(audience string, format string) url.Values {
+	query := url.Values{"audience": {audience}, "format": {format}}
+	if format == "full" {
+		query.Set("licenses", "TRUE")
+	}
+	return query
+}
 
-	flag.Parse()
-	if *awsAssumeRoleArn == "" || *eksClusterName == "" {
-		flag.Usage()
+func main() {
+	start := time.Now()
+	cfg, err := LoadFromCommandLine()
+	if err != nil {
+		logger.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+	if err := setupLogger(cfg); err != nil {
+		logger.Error("Failed to set up logging", "error", err)
 		os.Exit(1)
 	}
+	cfg.logWarnings()
+	if cfg.PrintVersion {
+		_, _ = fmt.Fprintln(os.Stdout, programName, buildVersion())
+		os.Exit(0)
+	}
+	if cfg.PrintFeatures {
+		_ = writeFeatures(os.Stdout)
+		os.Exit(0)
+	}
+	if cfg.PrintConfig {
+		cfg.logEffective()
+	}
+	if cfg.PrintEffective {
+		if err := cfg.printEffective(os.Stderr); err != nil {
+			logger.Error("Failed to print effective configuration", "error", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	validate := cfg.validate
+	if cfg.ProbeMetadata {
+		// The probe doesn't contact AWS, so the role and cluster aren't required
+		validate = cfg.validateProbe
+	}
+	if err := validate(); err != nil {
+		for _, problem := range ValidationErrors(err) {
+			logger.Error("Invalid configuration", "flag", problem.Flag, "error", problem.Err)
+		}
+		if !cfg.Quiet {
+			cfg.printUsage()
+		}
+		os.Exit(1)
+	}
+	// Cancel in-flight GCP and AWS calls when ArgoCD terminates the process or -timeout elapses
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	if cfg.ProbeMetadata {
+		if err := probeMetadata(ctx, os.Stdout, cfg); err != nil {
+			logger.Error("GCP metadata probe failed", "error", err)
+			os.Exit(exitCode(ctx, 1))
+		}
+		os.Exit(0)
+	}
+	execCredentialVersion, _ := resolveExecCredentialVersion(cfg)
+
+	if cfg.Mock && cfg.STSRegion == stsRegionAuto {
+		// There is no GCE zone to select the region from in mock mode
+		cfg.STSRegion = defaultSTSRegion
+	}
+	if cfg.STSRegion == stsRegionAuto {
+		err = newMetadataRetryPolicy(cfg).do(ctx, "gcp.metadata", func(ctx context.Context) (err error) {
+			cfg.STSRegion, err = selectSTSRegion(ctx, cfg, newMetadataHTTPClient(cfg))
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to select AWS STS region", "error", err)
+			os.Exit(exitCode(ctx, 1))
+		}
+	}
+	auth := NewAuthenticator(cfg)
+	if cfg.TokenStdin {
+		// Stdin is read fully before anything is written to stdout
+		retriever, err := NewStdinTokenRetriever(os.Stdin)
+		if err != nil {
+			logger.Error("Failed to read identity token from stdin", "error", err)
+			os.Exit(1)
+		}
+		auth.tokenRetriever = retriever
+	}
+	var issuer tokenIssuer = auth
+	if cfg.Mock {
+		issuer = newMockAuthenticator(auth)
+	}
 
-	ctx := context.Background()
+	if cfg.SelfTest {
+		if err := selfTest(ctx, os.Stderr, auth); err != nil {
+			logger.Error("Self-test failed", "error", err)
+			os.Exit(exitCode(ctx, 1))
+		}
+		os.Exit(0)
+	}
+	if cfg.DryRun {
+		if err := dryRun(ctx, os.Stderr, auth); err != nil {
+			logger.Error("Dry run failed", "error", err)
+			os.Exit(exitCode(ctx, 1))
+		}
+		os.Exit(0)
+	}
 
-	sessionIdentifier, err := createSessionIdentifier(gcpMetadataClient())
+	ctx, finishTracing, err := startTracing(ctx, cfg.OTLPEndpoint)
 	if err != nil {
-		logger.Error("Failed to create session identifier from GCP metadata, %s" + err.Error())
+		logger.Error("Failed to set up tracing", "error", err)
 		os.Exit(1)
 	}
+	// Deferred calls don't run on os.Exit, so spans are flushed explicitly
+	exit := func(code int) {
+		logger.Debug("Finished", "duration_ms", time.Since(start).Milliseconds(), "exitCode", code)
+		finishTracing()
+		os.Exit(code)
+	}
 
-	assumeRoleCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(*stsRegion))
+	var sessionIdentifier string
+	err = withSpan(ctx, "gcp.metadata", func(ctx context.Context) (err error) {
+		sessionIdentifier, err = issuer.GetSessionIdentifier(ctx)
+		return err
+	})
 	if err != nil {
-		logger.Error("failed to load default AWS config, %s" + err.Error())
-		os.Exit(1)
+		logger.Error("Failed to create session identifier from GCP metadata", "error", err)
+		exit(exitCode(ctx, 1))
+	}
+	if cfg.ValidateConfig {
+		logger.Info("Configuration is valid, no credentials were requested",
+			"roleArn", cfg.AWSRoleARN,
+			"cluster", cfg.EKSClusterName,
+			"stsRegion", cfg.STSRegion,
+			"clusterRegion", cfg.ClusterRegion,
+			"sessionName", sessionIdentifier,
+			"apiVersion", execCredentialVersion,
+		)
+		exit(0)
 	}
 
-	gcpMetadataToken, err := gcpRetrieveGCEVMToken(ctx)
+	if cfg.usesWebIdentity() && cfg.TokenFile == "" && !cfg.TokenStdin && cfg.Source("audience") == sourceDefault {
+		logger.Warn("GCP identity token is requested with the default audience, set -audience to the audience "+
+			"expected by the role trust policy or IAM OIDC provider when STS rejects it with InvalidIdentityToken",
+			"audience", defaultAudience)
+	}
+
+	if cfg.Serve != "" {
+		if err := serve(ctx, cfg, issuer, sessionIdentifier, execCredentialVersion); err != nil {
+			logger.Error("Server failed", "error", err)
+			exit(1)
+		}
+		exit(0)
+	}
+	exit(exitCode(ctx, run(ctx, cfg, issuer, sessionIdentifier, execCredentialVersion)))
+}
+
+// Returns expiration of the EKS token presigned with given credentials. The presigned URL
+// is valid for the requested lifetime of at most 15 minutes, but not past the expiration
+// of the credentials signing it, which may be sooner when the role has a short maximum
+// session duration. Expiration is set 1 minute before the earlier of the two for some cushion.
+func tokenExpiration(creds aws.Credentials, lifetime time.Duration) time.Time {
+	expiration := time.Now().Add(effectiveTokenExpiration(lifetime))
+	if creds.CanExpire && creds.Expires.Before(expiration) {
+		expiration = creds.Expires
+	}
+	return expiration.Add(-1 * time.Minute).Local()
+}
+
+// Returns exitCanceled for failures caused by a signal or -timeout, code otherwise
+func exitCode(ctx context.Context, code int) int {
+	if code != 0 && ctx.Err() != nil {
+		logger.Error("Aborted before a credential was issued", "error", context.Cause(ctx))
+		return exitCanceled
+	}
+	return code
+}
+
+// Issues a single ExecCredential, or AWS credentials with -output-format, and writes
+// it to stdout or the output file, returning the process exit code
+func run(ctx context.Context, cfg *Config, issuer tokenIssuer, sessionIdentifier string, execCredentialVersion string) int {
+	output, err := issueCredential(ctx, cfg, issuer, sessionIdentifier, execCredentialVersion)
 	if err != nil {
-		logger.Error("Failed to get JWT token from GCP metadata, %s" + err.Error())
-		os.Exit(1)
+		return authExitCode(err)
+	}
+
+	if cfg.OutputPath != "" {
+		if err := writeFileAtomic(cfg.OutputPath, []byte(output), 0600); err != nil {
+			logger.Error("Couldn't write credential to output file", "path", cfg.OutputPath, "error", err)
+			return 1
+		}
+		if !cfg.Quiet {
+			_, _ = fmt.Fprintln(os.Stdout, cfg.OutputPath)
+		}
+		return 0
 	}
+	_, _ = fmt.Fprint(os.Stdout, output)
+	return 0
+}
+
+// Issues an ExecCredential of given API version, or AWS credentials with -output-format,
+// for both the one-shot mode and -serve. Each phase is recorded as a span nested in the
+// root span, and failures are logged with the details of the failed phase.
+func issueCredential(ctx context.Context, cfg *Config, issuer tokenIssuer, sessionIdentifier string, execCredentialVersion string) (string, error) {
+	start := time.Now()
+	defer func() {
+		tokenMintDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "exec_credential")
+	defer span.End()
 
-	stsAssumeClient := sts.NewFromConfig(assumeRoleCfg)
-	awsCredsCache := aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
-		stsAssumeClient,
-		*awsAssumeRoleArn,
-		gcpMetadataToken,
-		func(o *stscreds.WebIdentityRoleOptions) {
-			o.RoleSessionName = sessionIdentifier
-		}),
-	)
+	var gcpMetadataToken customIdentityTokenRetriever
+	if cfg.usesWebIdentity() {
+		err := withSpan(ctx, "gcp.identity_token", func(ctx context.Context) (err error) {
+			gcpMetadataToken, err = issuer.GetIdentityToken(ctx)
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to get JWT token from GCP metadata", append([]any{"error", err}, authErrorAttrs(err)...)...)
+			return "", err
+		}
+	}
 
-	awsCredentials, err := awsCredsCache.Retrieve(ctx)
+	var awsCredentials aws.Credentials
+	err := withSpan(ctx, "aws.get_credentials", func(ctx context.Context) (err error) {
+		awsCredentials, err = issuer.GetCredentials(ctx, sessionIdentifier, gcpMetadataToken)
+		return err
+	}, attribute.String("aws.role_arn", cfg.AWSRoleARN))
+	if operation := cfg.credentialsOperation(); operation != "" {
+		observeSTSCall(operation, err)
+	}
 	if err != nil {
-		logger.Error("Couldn't retrieve AWS credentials %s", err)
-		os.Exit(1)
+		logCredentialsError(err, cfg.RetryHint, newRetryPolicy(cfg))
+		return "", err
 	}
 
-	eksSignerCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(*stsRegion),
-		config.WithCredentialsProvider(credentials.StaticCredentialsProvider{
-			Value: awsCredentials,
-		}),
-	)
+	if cfg.verifiesIdentity() {
+		err := withSpan(ctx, "aws.verify_account", func(ctx context.Context) error {
+			return issuer.VerifyAccount(ctx, awsCredentials)
+		})
+		if !cfg.Mock {
+			observeSTSCall("GetCallerIdentity", err)
+		}
+		if err != nil {
+			logger.Error("AWS credentials check failed", "error", err)
+			return "", err
+		}
+	}
+
+	if isCredentialsOutputFormat(cfg.OutputFormat) {
+		credentials, err := formatCredentials(awsCredentials, cfg.OutputFormat)
+		if err != nil {
+			logger.Error("Couldn't format AWS credentials", "format", cfg.OutputFormat, "error", err)
+			return "", err
+		}
+		return recordIssued(cfg, credentials, newAuditRecord(cfg, sessionIdentifier, awsCredentials.Expires))
+	}
+
+	var presignedURL string
+	err = withSpan(ctx, "aws.presign", func(ctx context.Context) (err error) {
+		presignedURL, err = issuer.GetPresignedCallerIdentityURL(ctx, awsCredentials)
+		return err
+	}, attribute.String("eks.cluster", cfg.EKSClusterName))
 	if err != nil {
-		logger.Error("Couldn't load AWS config using retrieved credentials %s", err)
-		os.Exit(1)
+		logger.Error("Couldn't presign GetCallerIdentity request", append([]any{"error", err}, authErrorAttrs(err)...)...)
+		return "", err
 	}
 
-	stsClient := sts.NewFromConfig(eksSignerCfg)
+	if cfg.PrintURL {
+		printPresignedURL(presignedURL)
+	}
 
-	presignclient := sts.NewPresignClient(stsClient)
-	presignedURLString, err := presignclient.PresignGetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}, func(opt *sts.PresignOptions) {
-		opt.Presigner = newCustomHTTPPresignerV4(opt.Presigner, map[string]string{
-			eksClusterIdHeader: *eksClusterName,
-			"X-Amz-Expires":    "60",
-		})
-	})
+	token := tokenV1Prefix + base64.RawURLEncoding.EncodeToString([]byte(presignedURL))
+	expiration := tokenExpiration(awsCredentials, cfg.TokenExpiration)
+	execCredential, err := formatJSON(token, expiration, execCredentialVersion)
+	if err != nil {
+		logger.Error("Couldn't format ExecCredential", "error", err)
+		return "", err
+	}
+	return recordIssued(cfg, execCredential, newAuditRecord(cfg, sessionIdentifier, expiration))
+}
 
-	token := tokenV1Prefix + base64.RawURLEncoding.EncodeToString([]byte(presignedURLString.URL))
-	// Set token expiration to 1 minute before the presigned URL expires for some cushion
-	tokenExpiration := time.Now().Local().Add(presignedURLExpiration - 1*time.Minute)
-	_, _ = fmt.Fprint(os.Stdout, formatJSON(token, tokenExpiration))
+// Logs the presigned URL along with its decoded query parameters at debug level and
+// prints it to stderr, never to stdout where the credential is written
+func printPresignedURL(presignedURL string) {
+	attrs := []any{"url", presignedURL}
+	if u, err := url.Parse(presignedURL); err == nil {
+		attrs = append(attrs, "host", u.Host, "query", u.Query())
+	}
+	logger.Debug("Presigned GetCallerIdentity URL", attrs...)
+	fmt.Fprintln(os.Stderr, presignedURL)
 }
 
-func formatJSON(token string, expiration time.Time) string {
+// Appends record of the issued credential to the audit log with -audit-log, returning
+// the credential only when that succeeds
+func recordIssued(cfg *Config, output string, record auditRecord) (string, error) {
+	if cfg.AuditLog != "" {
+		if err := appendAuditRecord(cfg.AuditLog, record); err != nil {
+			logger.Error("Couldn't append to audit log", "path", cfg.AuditLog, "error", err)
+			return "", err
+		}
+	}
+	return output, nil
+}
+
+// Writes data to a temporary file in the target directory and renames it into place,
+// so that readers never observe a partially written credential.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("chmod: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+	return nil
+}
+
+// Maps the short ExecCredential version name (v1, v1beta1) to its full API version
+func execCredentialAPIVersion(version string) (string, error) {
+	switch version {
+	case "v1":
+		return execCredentialV1, nil
+	case "v1beta1":
+		return execCredentialV1beta1, nil
+	case "v1alpha1":
+		return "", fmt.Errorf("ExecCredential version v1alpha1 was removed in Kubernetes 1.24, use v1 or v1beta1")
+	default:
+		return "", fmt.Errorf("unsupported ExecCredential version %q, expected v1 or v1beta1", version)
+	}
+}
+
+// Resolves the ExecCredential API version to emit. -api-version set on the command line,
+// in the environment or in the config file forces the version, otherwise the version the
+// client requests through KUBERNETES_EXEC_INFO is used, falling back to v1beta1.
+func resolveExecCredentialVersion(cfg *Config) (string, error) {
+	if cfg.Source("api-version") != sourceDefault {
+		return execCredentialAPIVersion(cfg.APIVersion)
+	}
+	return detectExecCredentialVersion(), nil
+}
+
+// Determines the ExecCredential API version requested by the client through the
+// KUBERNETES_EXEC_INFO environment variable, falling back to v1beta1 when the variable
+// is absent, malformed or requests an unsupported version.
+func detectExecCredentialVersion() string {
+	execInfo := os.Getenv("KUBERNETES_EXEC_INFO")
+	if execInfo == "" {
+		return execCredentialV1beta1
+	}
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal([]byte(execInfo), &typeMeta); err != nil {
+		logger.Warn("Couldn't parse KUBERNETES_EXEC_INFO, using default ExecCredential version", "error", err)
+		return execCredentialV1beta1
+	}
+	switch typeMeta.APIVersion {
+	case execCredentialV1, execCredentialV1beta1:
+		return typeMeta.APIVersion
+	default:
+		logger.Warn("Unsupported ExecCredential version in KUBERNETES_EXEC_INFO, using default", "apiVersion", typeMeta.APIVersion)
+		return execCredentialV1beta1
+	}
+}
+
+func formatJSON(token string, expiration time.Time, apiVersion string) (string, error) {
 	expirationTimestamp := metav1.NewTime(expiration)
-	execInput := &clientauthv1beta1.ExecCredential{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "client.authentication.k8s.io/v1beta1",
-			Kind:       "ExecCredential",
-		},
-		Status: &clientauthv1beta1.ExecCredentialStatus{
-			ExpirationTimestamp: &expirationTimestamp,
-			Token:               token,
-		},
-	}
-	enc, _ := json.Marshal(execInput)
-	return string(enc)
+	typeMeta := metav1.TypeMeta{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+	}
+
+	var execInput interface{}
+	switch apiVersion {
+	case execCredentialV1:
+		execInput = &clientauthv1.ExecCredential{
+			TypeMeta: typeMeta,
+			Status: &clientauthv1.ExecCredentialStatus{
+				ExpirationTimestamp: &expirationTimestamp,
+				Token:               token,
+			},
+		}
+	case execCredentialV1beta1:
+		execInput = &clientauthv1beta1.ExecCredential{
+			TypeMeta: typeMeta,
+			Status: &clientauthv1beta1.ExecCredentialStatus{
+				ExpirationTimestamp: &expirationTimestamp,
+				Token:               token,
+			},
+		}
+	default:
+		return "", fmt.Errorf("unsupported ExecCredential apiVersion %q", apiVersion)
+	}
+	enc, err := json.Marshal(execInput)
+	if err != nil {
+		return "", err
+	}
+	return string(enc), nil
 }
 
 type customIdentityTokenRetriever struct {
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+// Redirects the logger to a buffer for the duration of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := logger
+	logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() { logger = prev })
+	return &buf
+}
+
+// Wrappers parse the -features output, so any change to it must be deliberate
+func TestWriteFeatures(t *testing.T) {
+	want := []string{
+		"exec-credential-v1",
+		"exec-credential-v1beta1",
+		"gcp-metadata-identity-token",
+		"web-identity-federation",
+		"output-file",
+		"config-file",
+		"proxy-url",
+		"probe-metadata",
+		"dry-run",
+		"validate-config",
+		"cluster-region",
+		"aws-endpoint",
+		"sts-region-auto",
+		"print-effective-config",
+		"serve",
+		"otlp-tracing",
+		"selftest",
+		"presign-header",
+		"gcp-metadata-host",
+		"sts-vpc-endpoint",
+		"mock",
+		"token-file",
+		"token-stdin",
+		"output-format-aws-credential-process",
+		"token-format-eks",
+		"output-format-env",
+		"output-format-json",
+		"credential-source-ambient",
+		"credential-source-profile",
+		"audit-log",
+		"partition",
+		"signing-region",
+		"ca-bundle",
+	}
+	quoted := make([]string, len(want))
+	for i, feature := range want {
+		quoted[i] = strconv.Quote(feature)
+	}
+
+	var out bytes.Buffer
+	if err := writeFeatures(&out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "["+strings.Join(quoted, ",")+"]\n"; got != want {
+		t.Errorf("writeFeatures() = %s, want %s", got, want)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credential.json")
+	if err := os.WriteFile(path, []byte("old credential"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new credential"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new credential" {
+		t.Errorf("content = %q, want %q", got, "new credential")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("permissions = %v, want %v", perm, os.FileMode(0600))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the credential without temporary files", len(entries))
+	}
+}
+
+func TestWriteFileAtomicMissingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "credential.json"), []byte("credential"), 0600); err == nil {
+		t.Error("writeFileAtomic() succeeded in a missing directory")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("directory has %d entries after a failed write", len(entries))
+	}
+}
+
+// STS error answered by the fake STS endpoint
+type stsFailure struct {
+	status int
+	code   string
+}
+
+// Request received by the fake STS endpoint
+type fakeSTSRequest struct {
+	form          url.Values
+	userAgent     string
+	authorization string
+}
+
+// Fake STS endpoint failing the first requests with the queued failures and answering
+// AssumeRoleWithWebIdentity, AssumeRole and GetCallerIdentity successfully afterwards
+type fakeSTSServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures []stsFailure
+	requests []fakeSTSRequest
+}
+
+// Successful responses of the fake STS endpoint keyed by action
+var fakeSTSResponses = map[string]string{
+	"AssumeRoleWithWebIdentity": `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult><Credentials><AccessKeyId>ASIAFAKEACCESSKEY000</AccessKeyId>
+  <SecretAccessKey>fake-secret</SecretAccessKey><SessionToken>fake-session-token</SessionToken>
+  <Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`,
+	"AssumeRole": `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult><Credentials><AccessKeyId>ASIAFAKEACCESSKEY000</AccessKeyId>
+  <SecretAccessKey>fake-secret</SecretAccessKey><SessionToken>fake-session-token</SessionToken>
+  <Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult>
+</AssumeRoleResponse>`,
+	"GetCallerIdentity": `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult><Arn>arn:aws:sts::123456789012:assumed-role/test/session</Arn>
+  <UserId>AROAFAKE:session</UserId><Account>123456789012</Account></GetCallerIdentityResult>
+</GetCallerIdentityResponse>`,
+}
+
+func newFakeSTSServer(t *testing.T, failures ...stsFailure) *fakeSTSServer {
+	t.Helper()
+	s := &fakeSTSServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, fakeSTSRequest{form: r.PostForm, userAgent: r.UserAgent(), authorization: r.Header.Get("Authorization")})
+		var failure *stsFailure
+		if len(s.failures) > 0 {
+			failure = &s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/xml")
+		if failure != nil {
+			w.WriteHeader(failure.status)
+			fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>fake failure</Message></Error><RequestId>fake</RequestId></ErrorResponse>`, failure.code)
+			return
+		}
+		fmt.Fprint(w, fakeSTSResponses[r.PostForm.Get("Action")])
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Returns requests received so far
+func (s *fakeSTSServer) Requests() []fakeSTSRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// Returns STS client sending requests to the fake endpoint
+func (s *fakeSTSServer) client() *sts.Client {
+	return sts.New(sts.Options{Region: "us-east-1", BaseEndpoint: aws.String(s.URL)})
+}
+
+// Fake GCP metadata server answering paths below /computeMetadata/v1/ from values after
+// failing the first requests with the queued status codes
+type fakeMetadataServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	values   map[string]string
+	failures []int
+	requests []string
+}
+
+func newFakeMetadataServer(t *testing.T, values map[string]string, failures ...int) *fakeMetadataServer {
+	t.Helper()
+	s := &fakeMetadataServer{values: values, failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		failure := 0
+		if len(s.failures) > 0 {
+			failure = s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Metadata-Flavor", "Google")
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+			return
+		}
+		if failure != 0 {
+			http.Error(w, "fake failure", failure)
+			return
+		}
+		value, ok := s.values[strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, value)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Returns request URIs received so far
+func (s *fakeMetadataServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// Returns host:port of the fake server, as expected by GCE_METADATA_HOST
+func (s *fakeMetadataServer) host() string {
+	return s.Listener.Addr().String()
+}
+
+// Returns HTTP client whose connections to metadata.google.internal end up at the fake server
+func (s *fakeMetadataServer) client() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, s.host())
+		},
+	}}
+}
+
+func TestAssumeRoleExpiredToken(t *testing.T) {
+	srv := newFakeSTSServer(t, stsFailure{http.StatusBadRequest, "ExpiredTokenException"})
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	auth := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test",
+		"-aws-endpoint", srv.URL, "-max-retries", "0", "-assume-role-duration", "1h"))
+	source := auth.newWebIdentityCredentialSource("session", customIdentityTokenRetriever{token: []byte("gcp-token")})
+
+	_, err := auth.retrieveCredentials(context.Background(), source)
+	if !isExpiredTokenError(err) {
+		t.Fatalf("first retrieveCredentials() error = %v, want ExpiredTokenException", err)
+	}
+	creds, err := auth.retrieveCredentials(context.Background(), source)
+	if err != nil {
+		t.Fatalf("retried retrieveCredentials() error = %v", err)
+	}
+	if creds.AccessKeyID != "ASIAFAKEACCESSKEY000" {
+		t.Errorf("AccessKeyID = %q", creds.AccessKeyID)
+	}
+	requests := srv.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("STS requests = %d, want 2", len(requests))
+	}
+	for _, r := range requests {
+		if r.form.Get("WebIdentityToken") != "gcp-token" || r.form.Get("RoleSessionName") != "session" || r.form.Get("DurationSeconds") != "3600" {
+			t.Errorf("STS request form = %v", r.form)
+		}
+	}
+}
+
+func TestIsExpiredTokenError(t *testing.T) {
+	srv := newFakeSTSServer(t, stsFailure{http.StatusForbidden, "AccessDenied"})
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	auth := NewAuthenticator(loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", srv.URL, "-max-retries", "0"))
+	_, err := auth.retrieveCredentials(context.Background(),
+		auth.newWebIdentityCredentialSource("session", customIdentityTokenRetriever{token: []byte("gcp-token")}))
+	if err == nil || isExpiredTokenError(err) {
+		t.Errorf("isExpiredTokenError(%v) = true, want false for AccessDenied", err)
+	}
+	if isExpiredTokenError(errors.New("ExpiredTokenException")) {
+		t.Error("isExpiredTokenError() matched a plain error by its text")
+	}
+}
+
+func TestFormatJSONVersions(t *testing.T) {
+	expiration := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		apiVersion string
+		decode     func([]byte) (apiVersion, kind, token string, expiration time.Time, err error)
+	}{
+		{execCredentialV1, func(b []byte) (string, string, string, time.Time, error) {
+			var cred clientauthv1.ExecCredential
+			err := json.Unmarshal(b, &cred)
+			return cred.APIVersion, cred.Kind, cred.Status.Token, cred.Status.ExpirationTimestamp.Time, err
+		}},
+		{execCredentialV1beta1, func(b []byte) (string, string, string, time.Time, error) {
+			var cred clientauthv1beta1.ExecCredential
+			err := json.Unmarshal(b, &cred)
+			return cred.APIVersion, cred.Kind, cred.Status.Token, cred.Status.ExpirationTimestamp.Time, err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apiVersion, func(t *testing.T) {
+			out, err := formatJSON("k8s-aws-v1.token", expiration, tt.apiVersion)
+			if err != nil {
+				t.Fatalf("formatJSON() error = %v", err)
+			}
+			apiVersion, kind, token, exp, err := tt.decode([]byte(out))
+			if err != nil {
+				t.Fatalf("unmarshal %s: %v", out, err)
+			}
+			if apiVersion != tt.apiVersion || kind != "ExecCredential" {
+				t.Errorf("apiVersion, kind = %q, %q, want %q, ExecCredential", apiVersion, kind, tt.apiVersion)
+			}
+			if token != "k8s-aws-v1.token" {
+				t.Errorf("token = %q", token)
+			}
+			if !exp.Equal(expiration) {
+				t.Errorf("expirationTimestamp = %v, want %v", exp, expiration)
+			}
+		})
+	}
+}
+
+func TestFormatJSONUnsupportedVersion(t *testing.T) {
+	if _, err := formatJSON("token", time.Now(), "client.authentication.k8s.io/v1alpha1"); err == nil {
+		t.Error("formatJSON() accepted v1alpha1")
+	}
+}
+
+func TestExecCredentialAPIVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{"v1", execCredentialV1, false},
+		{"v1beta1", execCredentialV1beta1, false},
+		{"v1alpha1", "", true},
+		{"v2", "", true},
+	}
+	for _, tt := range tests {
+		got, err := execCredentialAPIVersion(tt.version)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("execCredentialAPIVersion(%q) = %q, %v, want %q, error %v", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDetectExecCredentialVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		execInfo string
+		want     string
+	}{
+		{"unset", "", execCredentialV1beta1},
+		{"v1", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`, execCredentialV1},
+		{"v1beta1", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{}}`, execCredentialV1beta1},
+		{"v1alpha1", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1alpha1"}`, execCredentialV1beta1},
+		{"malformed", `{"apiVersion":`, execCredentialV1beta1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLogs(t)
+			t.Setenv("KUBERNETES_EXEC_INFO", tt.execInfo)
+			if got := detectExecCredentialVersion(); got != tt.want {
+				t.Errorf("detectExecCredentialVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateSessionIdentifierHashed(t *testing.T) {
+	srv := newFakeMetadataServer(t, map[string]string{
+		"project/project-id": "secret-project-4711",
+		"instance/hostname":  "argocd-repo-server-7d9f.c.secret-project-4711.internal",
+	})
+	t.Setenv("GCE_METADATA_HOST", srv.host())
+	c := newMetadataHTTPClient(loadTestConfig(t))
+
+	plain, err := createSessionIdentifier(context.Background(), c, "{project}-{hostname}", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "secret-project-4711-argocd-repo-server-7d9f.c.secret-pr-37347438"; plain != want {
+		t.Errorf("session identifier = %q, want %q", plain, want)
+	}
+
+	hashed, err := createSessionIdentifier(context.Background(), c, "{project}-{hostname}", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := createSessionIdentifier(context.Background(), c, "{project}-{hostname}", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashed != again {
+		t.Errorf("hashed session identifier isn't stable: %q, %q", hashed, again)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(hashed) {
+		t.Errorf("hashed session identifier = %q, want 16 hex characters", hashed)
+	}
+	for _, raw := range []string{"secret", "project", "4711", "argocd", "repo", "server", "internal"} {
+		if strings.Contains(hashed, raw) {
+			t.Errorf("hashed session identifier %q contains %q", hashed, raw)
+		}
+	}
+}
+
+func TestSessionNameFormat(t *testing.T) {
+	srv := newFakeMetadataServer(t, map[string]string{
+		"project/project-id": "secret-project-4711",
+		"instance/hostname":  "argocd-repo-server-7d9f",
+	})
+	t.Setenv("GCE_METADATA_HOST", srv.host())
+	c := newMetadataHTTPClient(loadTestConfig(t))
+
+	got, err := createSessionIdentifier(context.Background(), c, "argocd@{hostname}", false)
+	if err != nil || got != "argocd@argocd-repo-server-7d9f" {
+		t.Errorf("createSessionIdentifier() = %q, %v, want argocd@argocd-repo-server-7d9f", got, err)
+	}
+	for format, valid := range map[string]bool{
+		"{project}-{hostname}": true,
+		"{hostname}":           true,
+		"static":               false,
+		"{zone}-{hostname}":    false,
+	} {
+		if err := validateSessionNameFormat(format); (err == nil) != valid {
+			t.Errorf("validateSessionNameFormat(%q) error = %v, want valid %v", format, err, valid)
+		}
+	}
+}
+
+func TestNormalizeSessionIdentifierProperty(t *testing.T) {
+	valid := regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	check := func(identifier string) bool {
+		got, err := normalizeSessionIdentifier(identifier)
+		if err != nil {
+			return len(sanitizeSessionIdentifier(identifier)) < minSessionNameLength
+		}
+		return valid.MatchString(got)
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+	for _, identifier := range []string{"p-", "proj-host name", "プロジェクト-ホスト", strings.Repeat("a", 64), strings.Repeat("ä", 64), "a:b/c"} {
+		if !check(identifier) {
+			t.Errorf("normalizeSessionIdentifier(%q) doesn't match %v", identifier, valid)
+		}
+	}
+	for _, identifier := range []string{"", "-", "é"} {
+		if _, err := normalizeSessionIdentifier(identifier); err == nil {
+			t.Errorf("normalizeSessionIdentifier(%q) error = nil, want too short error", identifier)
+		}
+	}
+}
+
+func TestResolveExecCredentialVersion(t *testing.T) {
+	const execInfoV1 = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`
+	const execInfoV1beta1 = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{}}`
+	tests := []struct {
+		name     string
+		args     []string
+		env      string
+		execInfo string
+		want     string
+		wantErr  bool
+	}{
+		{"default", nil, "", "", execCredentialV1beta1, false},
+		{"negotiated v1", nil, "", execInfoV1, execCredentialV1, false},
+		{"negotiated v1beta1", nil, "", execInfoV1beta1, execCredentialV1beta1, false},
+		{"forced by flag", []string{"-api-version", "v1beta1"}, "", execInfoV1, execCredentialV1beta1, false},
+		{"forced by env", nil, "v1", execInfoV1beta1, execCredentialV1, false},
+		{"forced without exec info", []string{"-api-version", "v1"}, "", "", execCredentialV1, false},
+		{"forced v1alpha1", []string{"-api-version", "v1alpha1"}, "", execInfoV1, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLogs(t)
+			t.Setenv("KUBERNETES_EXEC_INFO", tt.execInfo)
+			if tt.env != "" {
+				t.Setenv(envName("api-version"), tt.env)
+			}
+			got, err := resolveExecCredentialVersion(loadTestConfig(t, tt.args...))
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("resolveExecCredentialVersion() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGCPRetrieveGCEVMTokenQuery(t *testing.T) {
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+	client := md.client()
+
+	tests := []struct {
+		format   string
+		audience string
+		want     url.Values
+	}{
+		{"full", "gcp", url.Values{"audience": {"gcp"}, "format": {"full"}, "licenses": {"TRUE"}}},
+		{"standard", "sts.amazonaws.com", url.Values{"audience": {"sts.amazonaws.com"}, "format": {"standard"}}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			token, err := gcpRetrieveGCEVMToken(context.Background(), client, tt.audience, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(token.token) != "header.payload.signature" {
+				t.Errorf("token = %q", token.token)
+			}
+			requests := md.Requests()
+			if len(requests) != i+1 {
+				t.Fatalf("metadata requests = %q, want %d", requests, i+1)
+			}
+			u, err := url.Parse(requests[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" {
+				t.Errorf("path = %q", u.Path)
+			}
+			if got := u.Query(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCodeOnCancellation(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code int
+		want int
+	}{
+		{"success", canceled, 0, 0},
+		{"failure", context.Background(), 1, 1},
+		{"signal", canceled, 1, exitCanceled},
+		{"timeout", expired, 1, exitCanceled},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.ctx, tt.code); got != tt.want {
+			t.Errorf("%s: exitCode(%d) = %d, want %d", tt.name, tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Steps of issuing an EKS token, implemented by [Authenticator] and [mockAuthenticator]
+type tokenIssuer interface {
+	GetSessionIdentifier(ctx context.Context) (string, error)
+	GetIdentityToken(ctx context.Context) (customIdentityTokenRetriever, error)
+	GetCredentials(ctx context.Context, sessionIdentifier string, token customIdentityTokenRetriever) (aws.Credentials, error)
+	VerifyAccount(ctx context.Context, creds aws.Credentials) error
+	GetPresignedCallerIdentityURL(ctx context.Context, creds aws.Credentials) (string, error)
+}
+
+// Fake credentials returned in mock mode
+const (
+	mockAccessKeyID     = "ASIAMOCKACCESSKEY000"
+	mockSecretAccessKey = "mock/secret/access/key/0000000000000000"
+	mockSessionToken    = "mock-session-token"
+	mockSessionDuration = 1 * time.Hour
+)
+
+// Authenticator for -mock mode, returning deterministic fake GCP and AWS data without
+// contacting either. Presigning is done by the embedded [Authenticator] with the fake
+// credentials, which works offline and yields a syntactically valid EKS token.
+type mockAuthenticator struct {
+	*Authenticator
+}
+
+// Creates mock authenticator presigning with given authenticator
+func newMockAuthenticator(auth *Authenticator) *mockAuthenticator {
+	logger.Warn("Running in mock mode, the emitted credential is fake and won't authenticate to any cluster")
+	return &mockAuthenticator{Authenticator: auth}
+}
+
+// Returns session identifier of a fake GCP instance
+func (m *mockAuthenticator) GetSessionIdentifier(ctx context.Context) (string, error) {
+	if m.cfg.SessionName != "" {
+		return m.cfg.SessionName, nil
+	}
+	return shortenSessionIdentifier(sanitizeSessionIdentifier("mock-project-mock-instance")), nil
+}
+
+// Returns unsigned JWT with the configured audience
+func (m *mockAuthenticator) GetIdentityToken(ctx context.Context) (customIdentityTokenRetriever, error) {
+	claims, err := json.Marshal(jwtClaims{
+		Audience: m.cfg.Audience,
+		Subject:  "000000000000000000000",
+		Email:    "mock@mock-project.iam.gserviceaccount.com",
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return customIdentityTokenRetriever{}, fmt.Errorf("json.Marshal: %w", err)
+	}
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + enc.EncodeToString(claims) + "."
+	return customIdentityTokenRetriever{token: []byte(token)}, nil
+}
+
+// Returns fake temporary credentials
+func (m *mockAuthenticator) GetCredentials(ctx context.Context, sessionIdentifier string, token customIdentityTokenRetriever) (aws.Credentials, error) {
+	return aws.Credentials{
+		AccessKeyID:     mockAccessKeyID,
+		SecretAccessKey: mockSecretAccessKey,
+		SessionToken:    mockSessionToken,
+		Source:          "mock",
+		CanExpire:       true,
+		Expires:         time.Now().Add(mockSessionDuration),
+	}, nil
+}
+
+// Checks the expected account against the account of the role ARN
+func (m *mockAuthenticator) VerifyAccount(ctx context.Context, creds aws.Credentials) error {
+	roleARN, err := arn.Parse(m.cfg.AWSRoleARN)
+	if err != nil {
+		return fmt.Errorf("arn.Parse: %w", err)
+	}
+	if m.cfg.Verify {
+		logger.Info("Verified AWS credentials, the cluster must map this identity in aws-auth or access entries",
+			"arn", m.cfg.AWSRoleARN, "account", roleARN.AccountID, "userId", mockAccessKeyID)
+	}
+	if m.cfg.ExpectedAccount != "" && roleARN.AccountID != m.cfg.ExpectedAccount {
+		return fmt.Errorf("assumed role %s belongs to AWS account %s, expected %s", m.cfg.AWSRoleARN, roleARN.AccountID, m.cfg.ExpectedAccount)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Formats of the issued credential selected by -output-format
+const (
+	outputFormatExecCredential    = "exec-credential"
+	outputFormatCredentialProcess = "aws-credential-process"
+	outputFormatEnv               = "env"
+	outputFormatJSON              = "json"
+)
+
+// Reports whether the output format carries the AWS credentials instead of an EKS token
+func isCredentialsOutputFormat(format string) bool {
+	return format == outputFormatCredentialProcess || format == outputFormatEnv || format == outputFormatJSON
+}
+
+// Formats AWS credentials in given credentials output format
+func formatCredentials(creds aws.Credentials, format string) (string, error) {
+	switch format {
+	case outputFormatCredentialProcess:
+		return formatCredentialProcess(creds)
+	case outputFormatEnv:
+		return formatEnv(creds), nil
+	case outputFormatJSON:
+		return formatCredentialsJSON(creds)
+	}
+	return "", fmt.Errorf("unsupported output format %q", format)
+}
+
+// Formats of the EKS token selected by -token-format
+const (
+	// Presigned URL with X-Amz-Expires, as generated by aws-iam-authenticator
+	tokenFormatLegacy = "legacy"
+	// Presigned URL without X-Amz-Expires, validity is then decided by the cluster
+	tokenFormatEKS = "eks"
+)
+
+// Credentials in the format expected from an AWS CLI/SDK credential_process
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      string `json:",omitempty"`
+}
+
+// Formats AWS credentials as credential_process output, expiring with the STS session
+func formatCredentialProcess(creds aws.Credentials) (string, error) {
+	out := credentialProcessOutput{
+		Version:         1,
+		AccessKeyId:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}
+	if creds.CanExpire {
+		out.Expiration = creds.Expires.UTC().Format(time.RFC3339)
+	}
+	enc, err := json.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+	return string(enc), nil
+}
+
+// Formats AWS credentials as shell export statements with single-quoted values
+func formatEnv(creds aws.Credentials) string {
+	var b strings.Builder
+	for _, v := range [][2]string{
+		{"AWS_ACCESS_KEY_ID", creds.AccessKeyID},
+		{"AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", creds.SessionToken},
+	} {
+		fmt.Fprintf(&b, "export %s=%s\n", v[0], shellQuote(v[1]))
+	}
+	return b.String()
+}
+
+// Quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Raw AWS credentials
+type credentialsJSON struct {
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+	Expiration      string `json:"expiration,omitempty"`
+}
+
+// Formats AWS credentials as JSON
+func formatCredentialsJSON(creds aws.Credentials) (string, error) {
+	out := credentialsJSON{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}
+	if creds.CanExpire {
+		out.Expiration = creds.Expires.UTC().Format(time.RFC3339)
+	}
+	enc, err := json.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+	return string(enc), nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+func TestFormatCredentialProcess(t *testing.T) {
+	expires := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		name  string
+		creds aws.Credentials
+		want  string
+	}{
+		{
+			"expiring",
+			aws.Credentials{AccessKeyID: "ASIAKEY", SecretAccessKey: "secret", SessionToken: "session", CanExpire: true, Expires: expires},
+			`{"Version":1,"AccessKeyId":"ASIAKEY","SecretAccessKey":"secret","SessionToken":"session","Expiration":"2024-05-01T10:00:00Z"}`,
+		},
+		{
+			"non-expiring",
+			aws.Credentials{AccessKeyID: "AKIAKEY", SecretAccessKey: "secret"},
+			`{"Version":1,"AccessKeyId":"AKIAKEY","SecretAccessKey":"secret","SessionToken":""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatCredentialProcess(tt.creds)
+			if err != nil {
+				t.Fatalf("formatCredentialProcess() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("formatCredentialProcess() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueCredentialProcess(t *testing.T) {
+	captureLogs(t)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-mock", "-output-format", outputFormatCredentialProcess)
+	output, err := issueCredential(context.Background(), cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1beta1)
+	if err != nil {
+		t.Fatalf("issueCredential() error = %v", err)
+	}
+	var got credentialProcessOutput
+	if err := json.Unmarshal([]byte(output), &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", output, err)
+	}
+	if got.Version != 1 || got.AccessKeyId != mockAccessKeyID || got.Expiration == "" {
+		t.Errorf("credential_process output = %+v, want version 1 mock credentials with an expiration", got)
+	}
+}
+
+// Decodes the presigned URL from the EKS token of an ExecCredential
+func decodeTokenURL(t *testing.T, execCredential string) *url.URL {
+	t.Helper()
+	var cred clientauthv1beta1.ExecCredential
+	if err := json.Unmarshal([]byte(execCredential), &cred); err != nil {
+		t.Fatalf("unmarshal ExecCredential: %v", err)
+	}
+	encoded, ok := strings.CutPrefix(cred.Status.Token, tokenV1Prefix)
+	if !ok {
+		t.Fatalf("token %q doesn't start with %s", cred.Status.Token, tokenV1Prefix)
+	}
+	rawURL, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	u, err := url.Parse(string(rawURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestTokenFormat(t *testing.T) {
+	tests := []struct {
+		format      string
+		wantExpires string
+	}{
+		{tokenFormatLegacy, "60"},
+		{tokenFormatEKS, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			captureLogs(t)
+			cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-mock", "-token-format", tt.format)
+			output, err := issueCredential(context.Background(), cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1beta1)
+			if err != nil {
+				t.Fatalf("issueCredential() error = %v", err)
+			}
+			u := decodeTokenURL(t, output)
+			query := u.Query()
+			if query.Get("Action") != "GetCallerIdentity" || u.Host != "sts.us-east-1.amazonaws.com" {
+				t.Errorf("token URL = %s, want GetCallerIdentity on the regional STS endpoint", u)
+			}
+			if got := query.Get("X-Amz-Expires"); got != tt.wantExpires {
+				t.Errorf("X-Amz-Expires = %q, want %q", got, tt.wantExpires)
+			}
+			if !strings.Contains(query.Get("X-Amz-SignedHeaders"), "x-k8s-aws-id") {
+				t.Errorf("X-Amz-SignedHeaders = %q, missing x-k8s-aws-id", query.Get("X-Amz-SignedHeaders"))
+			}
+		})
+	}
+}
+
+func TestFormatEnv(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "ASIAKEY", SecretAccessKey: "it's a secret", SessionToken: "token with spaces"}
+	want := "export AWS_ACCESS_KEY_ID='ASIAKEY'\n" +
+		"export AWS_SECRET_ACCESS_KEY='it'\\''s a secret'\n" +
+		"export AWS_SESSION_TOKEN='token with spaces'\n"
+	if got := formatEnv(creds); got != want {
+		t.Errorf("formatEnv() = %q, want %q", got, want)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "''"},
+		{"plain", "'plain'"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{`"double" $HOME`, `'"double" $HOME'`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCredentials(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "ASIAKEY", SecretAccessKey: "secret", SessionToken: "session",
+		CanExpire: true, Expires: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{outputFormatJSON, `{"accessKeyId":"ASIAKEY","secretAccessKey":"secret","sessionToken":"session","expiration":"2024-05-01T10:00:00Z"}`},
+		{outputFormatEnv, "export AWS_ACCESS_KEY_ID='ASIAKEY'\nexport AWS_SECRET_ACCESS_KEY='secret'\nexport AWS_SESSION_TOKEN='session'\n"},
+	}
+	for _, tt := range tests {
+		got, err := formatCredentials(creds, tt.format)
+		if err != nil {
+			t.Fatalf("formatCredentials(%s) error = %v", tt.format, err)
+		}
+		if got != tt.want {
+			t.Errorf("formatCredentials(%s) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+	if _, err := formatCredentials(creds, outputFormatExecCredential); err == nil {
+		t.Error("formatCredentials() accepted the exec-credential format")
+	}
+}
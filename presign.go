@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Checks the presigned GetCallerIdentity URL before it's encoded into the EKS token, so
+// that a misconfiguration fails here with the reason instead of as Unauthorized from EKS
+func (a *Authenticator) validatePresignedURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("url.Parse: %w", err)
+	}
+	if a.cfg.AWSEndpoint != "" {
+		endpoint, err := url.Parse(a.cfg.AWSEndpoint)
+		if err != nil {
+			return fmt.Errorf("url.Parse: %w", err)
+		}
+		if u.Scheme != endpoint.Scheme || u.Host != endpoint.Host {
+			return fmt.Errorf("URL %s://%s doesn't match -aws-endpoint %s", u.Scheme, u.Host, a.cfg.AWSEndpoint)
+		}
+	} else {
+		if u.Scheme != "https" {
+			return fmt.Errorf("URL has scheme %q, expected https", u.Scheme)
+		}
+		if hosts := a.expectedSTSHosts(); !slices.Contains(hosts, u.Host) {
+			return fmt.Errorf("URL has host %q, expected one of %s", u.Host, strings.Join(hosts, ", "))
+		}
+	}
+
+	query := u.Query()
+	if action := query.Get("Action"); action != "GetCallerIdentity" {
+		return fmt.Errorf("URL has Action %q, expected GetCallerIdentity", action)
+	}
+	if expires := query.Get("X-Amz-Expires"); expires != "" {
+		seconds, err := strconv.Atoi(expires)
+		if err != nil || seconds < 0 || seconds > maxPresignExpires {
+			return fmt.Errorf("URL has X-Amz-Expires %q, expected at most %d seconds", expires, maxPresignExpires)
+		}
+	} else if a.cfg.TokenFormat == tokenFormatLegacy {
+		return fmt.Errorf("URL is missing X-Amz-Expires required by -token-format %s", tokenFormatLegacy)
+	}
+	// X-Amz-Credential is <access key>/<date>/<region>/sts/aws4_request
+	if scope := strings.Split(query.Get("X-Amz-Credential"), "/"); len(scope) != 5 || scope[2] != a.signingRegion() {
+		return fmt.Errorf("URL has X-Amz-Credential %q, expected credential scope of region %s", query.Get("X-Amz-Credential"), a.signingRegion())
+	}
+	signedHeaders := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	if !slices.Contains(signedHeaders, strings.ToLower(eksClusterIdHeader)) {
+		return fmt.Errorf("URL doesn't sign the %s header, X-Amz-SignedHeaders is %q", eksClusterIdHeader, query.Get("X-Amz-SignedHeaders"))
+	}
+	return nil
+}
+
+// Returns region the EKS token is signed for
+func (a *Authenticator) signingRegion() string {
+	if a.cfg.SigningRegion != "" {
+		return a.cfg.SigningRegion
+	}
+	if a.cfg.ClusterRegion != "" {
+		return a.cfg.ClusterRegion
+	}
+	return a.cfg.STSRegion
+}
+
+// Returns hosts of the STS endpoints EKS tokens may be presigned for: the regional
+// endpoint of the signing region, its FIPS and dual-stack variants, and the global
+// endpoint, which signs for us-east-1
+func (a *Authenticator) expectedSTSHosts() []string {
+	region := a.cfg.STSRegion
+	if a.cfg.ClusterRegion != "" {
+		region = a.cfg.ClusterRegion
+	}
+	partition := a.cfg.partition()
+	host := stsRegionalHost(partition, region)
+	hosts := []string{host, "sts-fips" + strings.TrimPrefix(host, "sts")}
+	if suffix, ok := awsDualStackPartitions[partition]; ok {
+		hosts = append(hosts, "sts."+region+"."+suffix, "sts-fips."+region+"."+suffix)
+	}
+	if partition == "aws" && region == "us-east-1" {
+		hosts = append(hosts, "sts.amazonaws.com")
+	}
+	return hosts
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Fake credentials presigning test URLs
+var presignTestCredentials = aws.Credentials{AccessKeyID: "ASIAFAKEACCESSKEY000", SecretAccessKey: "fake-secret", SessionToken: "fake-session-token"}
+
+// Presigns a GetCallerIdentity URL offline with fake credentials for configuration
+// given by args in addition to the required flags
+func presignTestURL(t *testing.T, args ...string) *url.URL {
+	t.Helper()
+	captureLogs(t)
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	cfg := loadTestConfig(t, append([]string{"-role-arn", testRoleARN, "-cluster", "test"}, args...)...)
+	rawURL, err := NewAuthenticator(cfg).GetPresignedCallerIdentityURL(context.Background(), presignTestCredentials)
+	if err != nil {
+		t.Fatalf("GetPresignedCallerIdentityURL() error = %v", err)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestPresignHeadersAreSigned(t *testing.T) {
+	u := presignTestURL(t, "-presign-header", "X-Team=platform", "-presign-header", "x-env=prod")
+	signed := strings.Split(u.Query().Get("X-Amz-SignedHeaders"), ";")
+	for _, header := range []string{"host", "x-k8s-aws-id", "x-team", "x-env"} {
+		if !slices.Contains(signed, header) {
+			t.Errorf("X-Amz-SignedHeaders = %q, missing %s", signed, header)
+		}
+	}
+	// Signed headers are sent by the validator, they aren't part of the query
+	for _, param := range []string{"x-k8s-aws-id", "X-Team", "X-Env"} {
+		if u.Query().Has(param) {
+			t.Errorf("presigned URL has header %s as query parameter", param)
+		}
+	}
+}
+
+func TestPresignHeaderRejectsReservedHeaders(t *testing.T) {
+	for _, header := range []string{"x-k8s-aws-id=other", "X-Amz-Date=20240101T000000Z", "X-Team", "bad header=x"} {
+		if err := (headersValue{}).Set(header); err == nil {
+			t.Errorf("Set(%q) succeeded", header)
+		}
+	}
+}
+
+func TestPresignedURLSignsExpires(t *testing.T) {
+	u := presignTestURL(t)
+	query := u.Query()
+	if got, want := query.Get("X-Amz-Expires"), strconv.Itoa(requestPresignParam); got != want {
+		t.Errorf("X-Amz-Expires = %q, want %q", got, want)
+	}
+	signed := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	for _, header := range []string{"host", "x-k8s-aws-id"} {
+		if !slices.Contains(signed, header) {
+			t.Errorf("X-Amz-SignedHeaders = %q, missing %s", signed, header)
+		}
+	}
+
+	// Presigning the same request again reproduces the signature only with the same
+	// X-Amz-Expires, so the parameter is covered by the signature
+	signingTime, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := strings.Split(query.Get("X-Amz-Credential"), "/")[2]
+	sign := func(expires string) string {
+		q := url.Values{"Action": query["Action"], "Version": query["Version"], "X-Amz-Expires": {expires}}
+		req, err := http.NewRequest(http.MethodGet, "https://"+u.Host+"/?"+q.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(eksClusterIdHeader, "test")
+		const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+		presigned, _, err := v4.NewSigner().PresignHTTP(context.Background(), presignTestCredentials, req, emptyPayloadHash, "sts", region, signingTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resigned, err := url.Parse(presigned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resigned.Query().Get("X-Amz-Signature")
+	}
+	if got, want := sign(query.Get("X-Amz-Expires")), query.Get("X-Amz-Signature"); got != want {
+		t.Fatalf("signature of re-presigned URL = %s, want %s", got, want)
+	}
+	if sign("900") == query.Get("X-Amz-Signature") {
+		t.Error("signature doesn't change with X-Amz-Expires")
+	}
+}
+
+func TestValidatePresignedURL(t *testing.T) {
+	const valid = "https://sts.us-east-1.amazonaws.com/?Action=GetCallerIdentity&Version=2011-06-15" +
+		"&X-Amz-Credential=ASIAFAKE%2F20240501%2Fus-east-1%2Fsts%2Faws4_request&X-Amz-Expires=60" +
+		"&X-Amz-SignedHeaders=host%3Bx-k8s-aws-id&X-Amz-Signature=abc"
+	tests := []struct {
+		name    string
+		args    []string
+		rawURL  string
+		wantErr string // Substring of the expected error, empty when valid
+	}{
+		{"valid", nil, valid, ""},
+		{"fips endpoint", nil, strings.Replace(valid, "sts.", "sts-fips.", 1), ""},
+		{"dual-stack endpoint", nil, strings.Replace(valid, "sts.us-east-1.amazonaws.com", "sts.us-east-1.api.aws", 1), ""},
+		{"fips dual-stack endpoint", nil, strings.Replace(valid, "sts.us-east-1.amazonaws.com", "sts-fips.us-east-1.api.aws", 1), ""},
+		{"global endpoint", nil, strings.Replace(valid, "sts.us-east-1.amazonaws.com", "sts.amazonaws.com", 1), ""},
+		{"global endpoint of another region", []string{"-sts-region", "eu-west-1"}, strings.Replace(valid, "sts.us-east-1.amazonaws.com", "sts.amazonaws.com", 1), `host "sts.amazonaws.com"`},
+		{"china dual-stack endpoint", []string{"-role-arn", "arn:aws-cn:iam::123456789012:role/test", "-sts-region", "cn-north-1"},
+			strings.NewReplacer("sts.us-east-1.amazonaws.com", "sts.cn-north-1.api.amazonwebservices.com.cn", "%2Fus-east-1%2F", "%2Fcn-north-1%2F").Replace(valid), ""},
+		{"scheme", nil, strings.Replace(valid, "https://", "http://", 1), `scheme "http"`},
+		{"host", nil, strings.Replace(valid, "sts.us-east-1", "sts.eu-west-1", 1), `host "sts.eu-west-1.amazonaws.com"`},
+		{"aws endpoint", []string{"-aws-endpoint", "https://sts.example.com"}, valid, "doesn't match -aws-endpoint"},
+		{"action", nil, strings.Replace(valid, "GetCallerIdentity", "AssumeRole", 1), `Action "AssumeRole"`},
+		{"expires too long", nil, strings.Replace(valid, "X-Amz-Expires=60", "X-Amz-Expires=901", 1), `X-Amz-Expires "901"`},
+		{"expires not a number", nil, strings.Replace(valid, "X-Amz-Expires=60", "X-Amz-Expires=soon", 1), `X-Amz-Expires "soon"`},
+		{"expires missing", nil, strings.Replace(valid, "&X-Amz-Expires=60", "", 1), "missing X-Amz-Expires"},
+		{"expires missing with eks format", []string{"-token-format", tokenFormatEKS}, strings.Replace(valid, "&X-Amz-Expires=60", "", 1), ""},
+		{"credential scope", nil, strings.Replace(valid, "%2Fus-east-1%2F", "%2Feu-west-1%2F", 1), "credential scope of region us-east-1"},
+		{"signing region scope", []string{"-aws-endpoint", "https://sts.us-east-1.amazonaws.com", "-signing-region", "eu-west-1"}, valid, "credential scope of region eu-west-1"},
+		{"cluster header not signed", nil, strings.Replace(valid, "host%3Bx-k8s-aws-id", "host", 1), "doesn't sign the x-k8s-aws-id header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLogs(t)
+			cfg := loadTestConfig(t, append([]string{"-role-arn", testRoleARN, "-cluster", "test"}, tt.args...)...)
+			err := NewAuthenticator(cfg).validatePresignedURL(tt.rawURL)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validatePresignedURL() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validatePresignedURL() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPresignCredentialScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantHost   string
+		wantRegion string
+	}{
+		{"sts region", []string{"-sts-region", "eu-west-1"}, "sts.eu-west-1.amazonaws.com", "eu-west-1"},
+		{"cluster region", []string{"-sts-region", "eu-west-1", "-cluster-region", "eu-central-1"}, "sts.eu-central-1.amazonaws.com", "eu-central-1"},
+		{"signing region", []string{"-aws-endpoint", "https://sts.internal.example.com", "-signing-region", "ap-southeast-2"}, "sts.internal.example.com", "ap-southeast-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := presignTestURL(t, tt.args...)
+			if u.Host != tt.wantHost {
+				t.Errorf("host = %s, want %s", u.Host, tt.wantHost)
+			}
+			// X-Amz-Credential is <access key>/<date>/<region>/sts/aws4_request
+			scope := strings.Split(u.Query().Get("X-Amz-Credential"), "/")
+			if len(scope) != 5 || scope[2] != tt.wantRegion || scope[3] != "sts" {
+				t.Errorf("X-Amz-Credential = %q, want scope of region %s", u.Query().Get("X-Amz-Credential"), tt.wantRegion)
+			}
+		})
+	}
+}
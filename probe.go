@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A single diagnostic step, returning short detail printed on success
+type probeStep struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// Runs diagnostic steps in order, printing status and timing of each to w.
+// Stops at the first failing step as later steps depend on the earlier ones.
+func runProbe(ctx context.Context, w io.Writer, steps []probeStep) error {
+	for _, step := range steps {
+		start := time.Now()
+		detail, err := step.run(ctx)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "FAIL  %-20s %8s  %s\n", step.name, elapsed, err)
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+		_, _ = fmt.Fprintf(w, "OK    %-20s %8s  %s\n", step.name, elapsed, detail)
+	}
+	return nil
+}
+
+// Checks GCP metadata server reachability and identity token issuance without touching AWS
+func probeMetadata(ctx context.Context, w io.Writer, cfg *Config) error {
+	httpClient := newMetadataHTTPClient(cfg)
+	return runProbe(ctx, w, []probeStep{
+		{"metadata server", func(ctx context.Context) (string, error) {
+			id, err := gcpMetadataValue(ctx, httpClient, "instance/id")
+			return "instance " + id, err
+		}},
+		{"project id", func(ctx context.Context) (string, error) {
+			return gcpMetadataValue(ctx, httpClient, "project/project-id")
+		}},
+		{"identity token", func(ctx context.Context) (string, error) {
+			token, err := gcpRetrieveGCEVMToken(ctx, httpClient, cfg.Audience, cfg.GCPTokenFormat)
+			return fmt.Sprintf("%d bytes", len(token.token)), err
+		}},
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunProbeStopsAtFirstFailure(t *testing.T) {
+	errUnreachable := errors.New("unreachable")
+	var ran []string
+	step := func(name, detail string, err error) probeStep {
+		return probeStep{name, func(context.Context) (string, error) {
+			ran = append(ran, name)
+			return detail, err
+		}}
+	}
+	var out bytes.Buffer
+	err := runProbe(context.Background(), &out, []probeStep{
+		step("first", "fine", nil),
+		step("second", "", errUnreachable),
+		step("third", "never", nil),
+	})
+	if !errors.Is(err, errUnreachable) || !strings.HasPrefix(err.Error(), "second: ") {
+		t.Errorf("runProbe() error = %v, want second step's error", err)
+	}
+	if strings.Join(ran, ",") != "first,second" {
+		t.Errorf("ran steps %q, want first and second", ran)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "OK    first ") || !strings.HasSuffix(lines[0], "  fine") ||
+		!strings.HasPrefix(lines[1], "FAIL  second ") || !strings.HasSuffix(lines[1], "  unreachable") {
+		t.Errorf("output =\n%s", out.String())
+	}
+}
+
+func TestProbeMetadataReportsFailingStep(t *testing.T) {
+	srv := newFakeMetadataServer(t, map[string]string{"project/project-id": "secret-project-4711"})
+	t.Setenv("GCE_METADATA_HOST", srv.host())
+
+	var out bytes.Buffer
+	err := probeMetadata(context.Background(), &out, &Config{HTTPTimeout: 5 * time.Second})
+	if err == nil || !strings.HasPrefix(err.Error(), "metadata server: ") {
+		t.Errorf("probeMetadata() error = %v, want metadata server failure", err)
+	}
+	if !strings.HasPrefix(out.String(), "FAIL  metadata server ") || strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("output =\n%s", out.String())
+	}
+	if got, want := srv.Requests(), []string{"/computeMetadata/v1/instance/id"}; !slices.Equal(got, want) {
+		t.Errorf("metadata requests = %q, want %q", got, want)
+	}
+}
+
+func TestProbeMetadataWithMetadataHost(t *testing.T) {
+	srv := newFakeMetadataServer(t, map[string]string{
+		"instance/id":        "4711",
+		"project/project-id": "secret-project-4711",
+		"instance/service-accounts/default/identity": "header.payload.signature",
+	})
+	// Only -gcp-metadata-host points at the fake server
+	t.Setenv("GCE_METADATA_HOST", "")
+
+	var out bytes.Buffer
+	err := probeMetadata(context.Background(), &out, &Config{HTTPTimeout: 5 * time.Second, MetadataHost: srv.host(), Audience: "gcp", GCPTokenFormat: "full"})
+	if err != nil {
+		t.Fatalf("probeMetadata() error = %v\n%s", err, out.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "  instance 4711") || !strings.HasSuffix(lines[2], "  24 bytes") {
+		t.Errorf("output =\n%s", out.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "OK    ") {
+			t.Errorf("step failed: %s", line)
+		}
+	}
+	if requests := srv.Requests(); len(requests) < 2 || requests[0] != "/computeMetadata/v1/instance/id" ||
+		!strings.HasPrefix(requests[len(requests)-1], "/computeMetadata/v1/instance/service-accounts/default/identity?") {
+		t.Errorf("metadata requests = %q", requests)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	stsRegionAuto    = "auto"      // -sts-region value selecting region from GCE zone
+	defaultSTSRegion = "us-east-1" // Region used when no mapping for GCP region exists
+)
+
+// Nearest AWS region for GCP regions, used with -sts-region auto
+var nearestAWSRegions = map[string]string{
+	"us-east1":                "us-east-1",
+	"us-east4":                "us-east-1",
+	"us-east5":                "us-east-2",
+	"us-central1":             "us-east-2",
+	"us-south1":               "us-east-2",
+	"us-west1":                "us-west-2",
+	"us-west2":                "us-west-1",
+	"us-west3":                "us-west-2",
+	"us-west4":                "us-west-1",
+	"northamerica-northeast1": "ca-central-1",
+	"northamerica-northeast2": "ca-central-1",
+	"southamerica-east1":      "sa-east-1",
+	"europe-west1":            "eu-west-3",
+	"europe-west2":            "eu-west-2",
+	"europe-west3":            "eu-central-1",
+	"europe-west4":            "eu-central-1",
+	"europe-west6":            "eu-central-2",
+	"europe-west8":            "eu-south-1",
+	"europe-west9":            "eu-west-3",
+	"europe-southwest1":       "eu-south-2",
+	"europe-north1":           "eu-north-1",
+	"europe-central2":         "eu-central-1",
+	"asia-east1":              "ap-east-1",
+	"asia-east2":              "ap-east-1",
+	"asia-northeast1":         "ap-northeast-1",
+	"asia-northeast2":         "ap-northeast-3",
+	"asia-northeast3":         "ap-northeast-2",
+	"asia-south1":             "ap-south-1",
+	"asia-south2":             "ap-south-1",
+	"asia-southeast1":         "ap-southeast-1",
+	"asia-southeast2":         "ap-southeast-3",
+	"australia-southeast1":    "ap-southeast-2",
+	"australia-southeast2":    "ap-southeast-4",
+	"me-west1":                "il-central-1",
+	"me-central1":             "me-central-1",
+	"africa-south1":           "af-south-1",
+}
+
+// Parses comma separated gcp-region=aws-region pairs
+func parseRegionMap(value string) (map[string]string, error) {
+	regions := map[string]string{}
+	if value == "" {
+		return regions, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		gcpRegion, awsRegion, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || gcpRegion == "" || awsRegion == "" {
+			return nil, fmt.Errorf("invalid region mapping %q, expected gcp-region=aws-region", pair)
+		}
+		regions[gcpRegion] = awsRegion
+	}
+	return regions, nil
+}
+
+// Selects AWS STS region nearest to the GCE zone of the instance. Mappings in
+// overrides take precedence over the built-in table.
+func resolveSTSRegion(ctx context.Context, httpClient *http.Client, overrides map[string]string) (string, error) {
+	zone, err := gcpMetadataValue(ctx, httpClient, "instance/zone")
+	if err != nil {
+		return "", fmt.Errorf("couldn't fetch zone from GCP metadata server: %w", err)
+	}
+	// The metadata server returns the zone as projects/<number>/zones/<zone>
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+	// Zones are named <region>-<letter>, e.g. europe-west1-b
+	gcpRegion := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		gcpRegion = zone[:i]
+	}
+
+	if awsRegion, ok := overrides[gcpRegion]; ok {
+		logger.Info("Selected AWS STS region from region map", "gcpRegion", gcpRegion, "stsRegion", awsRegion)
+		return awsRegion, nil
+	}
+	if awsRegion, ok := nearestAWSRegions[gcpRegion]; ok {
+		logger.Info("Selected AWS STS region nearest to GCP region", "gcpRegion", gcpRegion, "stsRegion", awsRegion)
+		return awsRegion, nil
+	}
+	logger.Warn("No AWS region mapped for GCP region, using default STS region", "gcpRegion", gcpRegion, "stsRegion", defaultSTSRegion)
+	return defaultSTSRegion, nil
+}
+
+// Resolves -sts-region auto to the AWS region nearest to the GCE zone. With ambient
+// credentials the program may run off GCP, so the default region is used when the
+// zone can't be fetched.
+func selectSTSRegion(ctx context.Context, cfg *Config, httpClient *http.Client) (string, error) {
+	regionMap, _ := parseRegionMap(cfg.STSRegionMap)
+	region, err := resolveSTSRegion(ctx, httpClient, regionMap)
+	if err != nil && !cfg.usesWebIdentity() {
+		logger.Warn("Couldn't select AWS STS region from the GCE zone, using default STS region", "stsRegion", defaultSTSRegion, "error", err)
+		return defaultSTSRegion, nil
+	}
+	return region, err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRegionMap(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"europe-west1=eu-west-1", map[string]string{"europe-west1": "eu-west-1"}, false},
+		{"europe-west1=eu-west-1, us-central1=us-east-1", map[string]string{"europe-west1": "eu-west-1", "us-central1": "us-east-1"}, false},
+		{"europe-west1", nil, true},
+		{"europe-west1=", nil, true},
+		{"=eu-west-1", nil, true},
+		{"europe-west1=eu-west-1,", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRegionMap(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRegionMap(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveSTSRegion(t *testing.T) {
+	tests := []struct {
+		name      string
+		zone      string
+		overrides map[string]string
+		want      string
+		wantWarn  bool
+	}{
+		{"built-in mapping", "europe-west3-a", nil, "eu-central-1", false},
+		{"override", "europe-west3-a", map[string]string{"europe-west3": "eu-west-1"}, "eu-west-1", false},
+		{"override of unmapped region", "mars-north1-b", map[string]string{"mars-north1": "eu-west-1"}, "eu-west-1", false},
+		{"unmapped region", "mars-north1-b", map[string]string{"europe-west3": "eu-west-1"}, defaultSTSRegion, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			md := newFakeMetadataServer(t, map[string]string{"instance/zone": "projects/123456789012/zones/" + tt.zone})
+			t.Setenv("GCE_METADATA_HOST", md.host())
+
+			got, err := resolveSTSRegion(context.Background(), newMetadataHTTPClient(loadTestConfig(t)), tt.overrides)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSTSRegion() = %q, want %q", got, tt.want)
+			}
+			if warned := strings.Contains(logs.String(), `"level":"WARN"`); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v\n%s", warned, tt.wantWarn, logs)
+			}
+		})
+	}
+}
+
+func TestResolveSTSRegionOffGCP(t *testing.T) {
+	// Nothing listens on the metadata host outside of GCP
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GCE_METADATA_HOST", l.Addr().String())
+	l.Close()
+
+	if region, err := resolveSTSRegion(context.Background(), newMetadataHTTPClient(loadTestConfig(t)), nil); err == nil {
+		t.Errorf("resolveSTSRegion() = %q, want error", region)
+	}
+}
+
+func TestSelectSTSRegionOffGCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GCE_METADATA_HOST", l.Addr().String())
+	l.Close()
+
+	tests := []struct {
+		source  string
+		wantErr bool
+	}{
+		{credentialSourceWebIdentity, true},
+		{credentialSourceAmbient, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			logs := captureLogs(t)
+			cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-sts-region", "auto", "-credential-source", tt.source)
+			region, err := selectSTSRegion(context.Background(), cfg, newMetadataHTTPClient(cfg))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectSTSRegion() = %q, %v, wantErr %v", region, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if region != defaultSTSRegion {
+				t.Errorf("selectSTSRegion() = %q, want %q", region, defaultSTSRegion)
+			}
+			if len(logEntries(t, logs.String(), "Couldn't select AWS STS region from the GCE zone, using default STS region")) != 1 {
+				t.Errorf("fallback warning not logged: %s", logs)
+			}
+		})
+	}
+}
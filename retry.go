@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const maxRetryBackoff = 20 * time.Second // Upper bound of a single backoff delay
+
+// Retry policy applied to GCP metadata and AWS STS calls
+type retryPolicy struct {
+	maxRetries int           // Number of retries after the first attempt
+	backoff    time.Duration // Base delay, doubled on every retry
+}
+
+// Creates retry policy from -max-retries and -retry-backoff
+func newRetryPolicy(cfg *Config) retryPolicy {
+	return retryPolicy{maxRetries: cfg.MaxRetries, backoff: cfg.RetryBackoff}
+}
+
+// Creates retry policy of GCP metadata server calls, retrying -gcp-metadata-retries
+// times, which defaults to -max-retries
+func newMetadataRetryPolicy(cfg *Config) retryPolicy {
+	policy := newRetryPolicy(cfg)
+	policy.maxRetries = cfg.MetadataRetries
+	return policy
+}
+
+// Returns exponential backoff delay with jitter for given retry attempt (starting at 1)
+func (p retryPolicy) delay(attempt int) time.Duration {
+	if p.backoff <= 0 {
+		return 0
+	}
+	d := p.backoff << (attempt - 1)
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	// Equal jitter, keep at least half of the computed delay
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Calls fn until it succeeds, returns an error that is not retryable, or retries are
+// exhausted. Context passed to fn carries the attempt number.
+func (p retryPolicy) do(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(withCallAttempt(ctx, attempt+1))
+		if err == nil || attempt >= p.maxRetries || ctx.Err() != nil || !isRetryableError(err) {
+			return err
+		}
+		delay := p.delay(attempt + 1)
+		logger.Warn("Retrying failed call", "operation", operation, "attempt", attempt+1, "delay", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Errors retried by the AWS SDK retryer: the SDK defaults (throttling, 5xx and transient
+// connection errors) and IDPCommunicationError, returned when STS transiently fails to
+// reach the GCP identity provider to validate the token
+var stsRetryables = append([]retry.IsErrorRetryable{
+	retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
+		var idpErr *types.IDPCommunicationErrorException
+		if errors.As(err, &idpErr) {
+			return aws.TrueTernary
+		}
+		// Leave other errors to the SDK defaults
+		return aws.UnknownTernary
+	}),
+}, retry.DefaultRetryables...)
+
+// Creates AWS SDK retryer honoring the policy. The adaptive mode additionally rate
+// limits attempts on the client side while STS is throttling.
+func (p retryPolicy) awsRetryer() aws.Retryer {
+	return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+		o.StandardOptions = append(o.StandardOptions, func(o *retry.StandardOptions) {
+			o.MaxAttempts = p.maxRetries + 1
+			o.Retryables = stsRetryables
+			o.Backoff = retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
+				delay := p.delay(attempt)
+				logger.Debug("Retrying failed AWS call", "attempt", attempt, "delay", delay.String(), "error", err)
+				return delay, nil
+			})
+		})
+	})
+}
+
+// Error returned for unexpected HTTP status codes from GCP metadata server
+type httpStatusError struct {
+	statusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("status code %d", e.statusCode)
+}
+
+// Reports whether err is a transient failure (timeout, refused or reset connection, 5xx
+// or throttling). Other transport errors, such as TLS verification failures or invalid
+// URLs, fail the same way on every attempt and aren't retried.
+func isRetryableError(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode >= 500 || statusErr.statusCode == http.StatusTooManyRequests
+	}
+	// Every *url.Error is a net.Error, so only its Timeout method is meaningful
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryHonorsMaxRetries(t *testing.T) {
+	transient := &httpStatusError{statusCode: http.StatusServiceUnavailable}
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		maxRetries   int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"succeeds first time", 0, transient, 2, false, 1},
+		{"succeeds after retries", 2, transient, 2, false, 3},
+		{"retries exhausted", 3, transient, 2, true, 3},
+		{"no retries", 1, transient, 0, true, 1},
+		{"throttling is retried", 1, &httpStatusError{statusCode: http.StatusTooManyRequests}, 1, false, 2},
+		{"client error isn't retried", 1, &httpStatusError{statusCode: http.StatusNotFound}, 2, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			policy := retryPolicy{maxRetries: tt.maxRetries, backoff: time.Millisecond}
+			err := policy.do(context.Background(), "test", func(context.Context) error {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	attempts := 0
+	policy := retryPolicy{maxRetries: 10, backoff: time.Second}
+	err := policy.do(ctx, "test", func(context.Context) error {
+		attempts++
+		return &httpStatusError{statusCode: http.StatusServiceUnavailable}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	if d := (retryPolicy{backoff: 0}).delay(3); d != 0 {
+		t.Errorf("delay with zero backoff = %s, want 0", d)
+	}
+	p := retryPolicy{backoff: 100 * time.Millisecond}
+	for attempt := 1; attempt <= 10; attempt++ {
+		limit := min(p.backoff<<(attempt-1), maxRetryBackoff)
+		if d := p.delay(attempt); d < limit/2 || d > limit {
+			t.Errorf("delay(%d) = %s, want between %s and %s", attempt, d, limit/2, limit)
+		}
+	}
+}
+
+// Returns error of a GET request to url with client
+func getError(t *testing.T, client *http.Client, url string) error {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("GET %s succeeded", url)
+	}
+	return err
+}
+
+func TestIsRetryableError(t *testing.T) {
+	// A listener closed right away refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedURL := "http://" + l.Addr().String()
+	l.Close()
+
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(tlsServer.Close)
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(slowServer.Close)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &httpStatusError{statusCode: http.StatusBadGateway}, true},
+		{"throttling", &httpStatusError{statusCode: http.StatusTooManyRequests}, true},
+		{"wrapped server error", fmt.Errorf("metadata: %w", &httpStatusError{statusCode: http.StatusServiceUnavailable}), true},
+		{"timeout", getError(t, &http.Client{Timeout: 10 * time.Millisecond}, slowServer.URL), true},
+		{"connection refused", getError(t, http.DefaultClient, refusedURL), true},
+		{"connection reset", &url.Error{Op: "Get", URL: "http://metadata.google.internal", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, true},
+		{"not found", &httpStatusError{statusCode: http.StatusNotFound}, false},
+		{"forbidden", &httpStatusError{statusCode: http.StatusForbidden}, false},
+		{"untrusted certificate", getError(t, http.DefaultClient, tlsServer.URL), false},
+		{"unsupported scheme", getError(t, http.DefaultClient, "ftp://metadata.google.internal/"), false},
+		{"x509 error", &url.Error{Op: "Get", URL: "https://sts.amazonaws.com", Err: x509.UnknownAuthorityError{}}, false},
+		{"tls alert", &url.Error{Op: "Get", URL: "https://sts.amazonaws.com", Err: tls.AlertError(42)}, false},
+		{"file not found", &os.PathError{Op: "open", Path: "token", Err: syscall.ENOENT}, false},
+		{"plain error", errors.New("failed"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableError(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryableError(%v) = %t, want %t", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSTSRetriesTransientFailures(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	tests := []struct {
+		name    string
+		failure stsFailure
+	}{
+		{"service unavailable", stsFailure{http.StatusServiceUnavailable, "ServiceUnavailable"}},
+		{"identity provider unreachable", stsFailure{http.StatusBadRequest, "IDPCommunicationError"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			srv := newFakeSTSServer(t, tt.failure, tt.failure)
+			cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", srv.URL,
+				"-max-retries", "2", "-retry-backoff", "1ms")
+
+			_, err := NewAuthenticator(cfg).GetCredentials(context.Background(), "session", customIdentityTokenRetriever{token: []byte("gcp-token")})
+			if err != nil {
+				t.Fatalf("GetCredentials() error = %v", err)
+			}
+			if n := len(srv.Requests()); n != 3 {
+				t.Errorf("STS attempts = %d, want 3", n)
+			}
+			if n := len(logEntries(t, logs.String(), "Retrying failed AWS call")); n != 2 {
+				t.Errorf("logged retries = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestMetadataRetriesDefaultToMaxRetries(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, 2},
+		{[]string{"-max-retries", "5"}, 5},
+		{[]string{"-max-retries", "5", "-gcp-metadata-retries", "1"}, 1},
+		{[]string{"-gcp-metadata-retries", "0"}, 0},
+	}
+	for _, tt := range tests {
+		c := loadTestConfig(t, tt.args...)
+		if got := newMetadataRetryPolicy(c).maxRetries; got != tt.want {
+			t.Errorf("%q: metadata retry policy maxRetries = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSessionIdentifierRetriesMetadataFailures(t *testing.T) {
+	captureLogs(t)
+	md := newFakeMetadataServer(t, map[string]string{
+		"project/project-id": "project",
+		"instance/hostname":  "argocd-repo-server",
+	}, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-gcp-metadata-host", md.host(),
+		"-max-retries", "0", "-gcp-metadata-retries", "2", "-retry-backoff", "1ms")
+
+	got, err := NewAuthenticator(cfg).GetSessionIdentifier(context.Background())
+	if err != nil || got != "project-argocd-repo-server" {
+		t.Fatalf("GetSessionIdentifier() = %q, %v, want project-argocd-repo-server", got, err)
+	}
+	// Two failed project lookups, then the project and hostname
+	if n := len(md.Requests()); n != 4 {
+		t.Errorf("metadata requests = %d, want 4: %q", n, md.Requests())
+	}
+}
+
+func TestSessionIdentifierRetryStopsOnCanceledContext(t *testing.T) {
+	captureLogs(t)
+	failures := make([]int, 100)
+	for i := range failures {
+		failures[i] = http.StatusTooManyRequests
+	}
+	md := newFakeMetadataServer(t, nil, failures...)
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-gcp-metadata-host", md.host(),
+		"-gcp-metadata-retries", "10", "-retry-backoff", "1s")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := NewAuthenticator(cfg).GetSessionIdentifier(ctx); err == nil {
+		t.Fatal("GetSessionIdentifier() succeeded against a failing metadata server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetSessionIdentifier() took %v after the context was canceled", elapsed)
+	}
+	if n := len(md.Requests()); n != 1 {
+		t.Errorf("metadata requests = %d, want 1", n)
+	}
+}
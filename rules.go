@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Rule over a combination of flags
+type flagRule struct {
+	check   func(c *Config) bool // Reports whether the rule is violated
+	flag    string               // Flag the violation is reported for
+	message string
+}
+
+// Rule violated when both flags are set
+func conflicts(a, b string) flagRule {
+	return flagRule{
+		check:   func(c *Config) bool { return c.isSet(a) && c.isSet(b) },
+		flag:    a,
+		message: fmt.Sprintf("can't be used together with -%s", b),
+	}
+}
+
+// Rule violated when flag, which only applies to the GCP identity token, is set
+// with another credential source
+func onlyWithWebIdentity(flag string) flagRule {
+	return flagRule{
+		check:   func(c *Config) bool { return c.isSet(flag) && !c.usesWebIdentity() },
+		flag:    flag,
+		message: "can only be used with -credential-source web-identity",
+	}
+}
+
+// Rule violated when flag is set without the flag it depends on
+func requires(flag, dependency string) flagRule {
+	return flagRule{
+		check:   func(c *Config) bool { return c.isSet(flag) && !c.isSet(dependency) },
+		flag:    flag,
+		message: fmt.Sprintf("requires -%s", dependency),
+	}
+}
+
+// Incompatible and dependent flags
+var flagRules = []flagRule{
+	conflicts("dry-run", "validate-config"),
+	conflicts("dry-run", "output"),
+	conflicts("validate-config", "output"),
+	conflicts("probe-metadata", "dry-run"),
+	conflicts("probe-metadata", "validate-config"),
+	conflicts("selftest", "dry-run"),
+	conflicts("selftest", "validate-config"),
+	conflicts("selftest", "probe-metadata"),
+	conflicts("selftest", "output"),
+	conflicts("selftest", "serve"),
+	conflicts("mock", "dry-run"),
+	conflicts("mock", "selftest"),
+	conflicts("mock", "probe-metadata"),
+	conflicts("token-file", "mock"),
+	conflicts("token-file", "gcp-token-format"),
+	conflicts("token-stdin", "token-file"),
+	conflicts("token-stdin", "mock"),
+	conflicts("token-stdin", "gcp-token-format"),
+	requires("signing-region", "aws-endpoint"),
+	conflicts("sts-vpc-endpoint", "aws-endpoint"),
+	conflicts("sts-vpc-endpoint", "proxy-url"),
+	conflicts("session-name", "session-name-hash"),
+	conflicts("session-name", "session-name-format"),
+	conflicts("serve", "output"),
+	conflicts("serve", "dry-run"),
+	conflicts("serve", "validate-config"),
+	conflicts("serve", "timeout"),
+	requires("serve-token-file", "serve"),
+	conflicts("serve", "output-format"),
+	conflicts("quiet", "log-file"),
+	conflicts("print-url", "quiet"),
+	onlyWithWebIdentity("audience"),
+	onlyWithWebIdentity("verify-audience"),
+	onlyWithWebIdentity("gcp-token-format"),
+	onlyWithWebIdentity("token-file"),
+	onlyWithWebIdentity("token-stdin"),
+	onlyWithWebIdentity("session-name-format"),
+	onlyWithWebIdentity("session-name-hash"),
+	onlyWithWebIdentity("dry-run"),
+	onlyWithWebIdentity("selftest"),
+}
+
+// Checks all flag rules, returning every violation
+func (c *Config) validateFlagCombinations() []error {
+	var errs []error
+	for _, rule := range flagRules {
+		if rule.check(c) {
+			errs = append(errs, &ValidationError{Flag: rule.flag, Err: errors.New(rule.message)})
+		}
+	}
+	return errs
+}
+
+// Reports whether flag is set to a value different from its default
+func (c *Config) isSet(name string) bool {
+	f := c.fs.Lookup(name)
+	return f != nil && f.Value.String() != f.DefValue
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFlagRules(t *testing.T) {
+	required := []string{"-role-arn", testRoleARN, "-cluster", "test"}
+	tests := []struct {
+		name string
+		args []string
+		want []string // Reported violations
+	}{
+		{"valid", nil, nil},
+		{"dry-run with output", []string{"-dry-run", "-output", "out.json"}, []string{"-dry-run: can't be used together with -output"}},
+		{"probe with validate-config", []string{"-probe-metadata", "-validate-config"}, []string{"-probe-metadata: can't be used together with -validate-config"}},
+		{"quiet without output", []string{"-quiet"}, nil},
+		{"quiet with log-file", []string{"-quiet", "-log-file", "plugin.log"}, []string{"-quiet: can't be used together with -log-file"}},
+		{"serve-token-file without serve", []string{"-serve-token-file", "token"}, []string{"-serve-token-file: requires -serve"}},
+		{"several violations", []string{"-dry-run", "-validate-config", "-output", "out.json"}, []string{
+			"-dry-run: can't be used together with -validate-config",
+			"-dry-run: can't be used together with -output",
+			"-validate-config: can't be used together with -output",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadTestConfig(t, append(required, tt.args...)...)
+			err := errors.Join(c.validateFlagCombinations()...)
+			var got []string
+			if err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("violations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string // Substring of the expected error, empty when valid
+	}{
+		{"without role and cluster", []string{"-probe-metadata"}, ""},
+		{"with dry-run", []string{"-probe-metadata", "-dry-run"}, "-probe-metadata: can't be used together with -dry-run"},
+		{"invalid token format", []string{"-probe-metadata", "-gcp-token-format", "compact"}, "-gcp-token-format"},
+		{"zero http timeout", []string{"-probe-metadata", "-http-timeout", "0s"}, "-http-timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadTestConfig(t, tt.args...).validateProbe()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validateProbe() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validateProbe() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
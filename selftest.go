@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Checks end-to-end connectivity: GCP metadata server, identity token, STS
+// AssumeRoleWithWebIdentity and presigning of the EKS token. Prints a pass/fail line
+// per step to w, never the token itself, and stops at the first failure.
+func selfTest(ctx context.Context, w io.Writer, auth *Authenticator) error {
+	var (
+		sessionIdentifier string
+		token             customIdentityTokenRetriever
+		creds             aws.Credentials
+	)
+	return runProbe(ctx, w, []probeStep{
+		{"metadata server", func(ctx context.Context) (string, error) {
+			id, err := gcpMetadataValue(ctx, auth.metadataHTTPClient, "instance/id")
+			return "instance " + id, err
+		}},
+		{"session identifier", func(ctx context.Context) (detail string, err error) {
+			sessionIdentifier, err = auth.GetSessionIdentifier(ctx)
+			return sessionIdentifier, err
+		}},
+		{"gcp identity token", func(ctx context.Context) (string, error) {
+			var err error
+			token, err = auth.GetIdentityToken(ctx)
+			return fmt.Sprintf("%d bytes", len(token.token)), err
+		}},
+		{"assume role", func(ctx context.Context) (string, error) {
+			var err error
+			creds, err = auth.GetCredentials(ctx, sessionIdentifier, token)
+			return auth.cfg.AWSRoleARN, err
+		}},
+		{"presign", func(ctx context.Context) (string, error) {
+			presignedURL, err := auth.GetPresignedCallerIdentityURL(ctx, creds)
+			if err != nil {
+				return "", err
+			}
+			u, err := url.Parse(presignedURL)
+			if err != nil {
+				return "", fmt.Errorf("presigned URL: %w", err)
+			}
+			return fmt.Sprintf("%s for cluster %s", u.Host, auth.cfg.EKSClusterName), nil
+		}},
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposed on /metrics in serve mode
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	stsCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_auth_sts_calls_total",
+		Help: "AWS STS calls by operation and result.",
+	}, []string{"operation", "result"})
+
+	credentialRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_auth_credential_requests_total",
+		Help: "Requests to the /credentials endpoint by HTTP status code.",
+	}, []string{"code"})
+
+	tokenMintDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_auth_token_mint_duration_seconds",
+		Help:    "Time to mint an EKS token, from fetching the GCP identity token to presigning.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	identityTokenCacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_auth_identity_token_cache_lookups_total",
+		Help: "Lookups of cached GCP identity tokens by result, hit or miss.",
+	}, []string{"result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(stsCallsTotal, credentialRequestsTotal, tokenMintDuration, identityTokenCacheLookupsTotal)
+}
+
+// Records result of an STS call
+func observeSTSCall(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	stsCallsTotal.WithLabelValues(operation, result).Inc()
+}
+
+// Records lookup of a cached GCP identity token
+func observeTokenCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	identityTokenCacheLookupsTotal.WithLabelValues(result).Inc()
+}
+
+// Reports whether the request carries the bearer token read from -serve-token-file
+func authorized(r *http.Request, tokenFile string) (bool, error) {
+	// Re-read on every request to pick up rotated tokens, e.g. of a mounted secret
+	token, err := FileTokenRetriever{Path: tokenFile}.GetIdentityToken()
+	if err != nil {
+		return false, err
+	}
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(bearer), token) == 1, nil
+}
+
+// Returns handler of /credentials minting a new ExecCredential of given API version on
+// every GET request, requiring the bearer token with -serve-token-file
+func credentialsHandler(cfg *Config, issuer tokenIssuer, sessionIdentifier string, version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			credentialRequestsTotal.WithLabelValues(fmt.Sprint(http.StatusMethodNotAllowed)).Inc()
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if tokenFile := cfg.ServeTokenFile; tokenFile != "" {
+			ok, err := authorized(r, tokenFile)
+			if err != nil {
+				logger.Error("Couldn't read serve token file", "path", tokenFile, "error", err)
+				credentialRequestsTotal.WithLabelValues(fmt.Sprint(http.StatusInternalServerError)).Inc()
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				credentialRequestsTotal.WithLabelValues(fmt.Sprint(http.StatusUnauthorized)).Inc()
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		execCredential, err := issueCredential(r.Context(), cfg, issuer, sessionIdentifier, version)
+		if err != nil {
+			credentialRequestsTotal.WithLabelValues(fmt.Sprint(http.StatusBadGateway)).Inc()
+			http.Error(w, "couldn't mint credential", http.StatusBadGateway)
+			return
+		}
+		credentialRequestsTotal.WithLabelValues(fmt.Sprint(http.StatusOK)).Inc()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, execCredential)
+	})
+}
+
+// Serves ExecCredentials on /credentials and Prometheus metrics on /metrics until
+// the server fails or ctx is canceled, in which case the server is shut down gracefully
+func serve(ctx context.Context, cfg *Config, issuer tokenIssuer, sessionIdentifier string, version string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.Handle("/credentials", credentialsHandler(cfg, issuer, sessionIdentifier, version))
+
+	server := &http.Server{
+		Addr:              cfg.Serve,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if cfg.ServeTokenFile == "" {
+		logger.Warn("Serving credentials without authentication, set -serve-token-file to require a bearer token on /credentials", "addr", cfg.Serve)
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	logger.Info("Serving credentials and metrics", "addr", cfg.Serve)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("Server stopped", "cause", context.Cause(ctx))
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCredentialsHandler(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+
+	tests := []struct {
+		name     string
+		method   string
+		failures []stsFailure
+		want     int
+		result   string // Result label of the counted STS call, empty when STS isn't called
+	}{
+		{"issued", http.MethodGet, nil, http.StatusOK, "success"},
+		{"sts failure", http.MethodGet, []stsFailure{{http.StatusForbidden, "AccessDenied"}}, http.StatusBadGateway, "error"},
+		{"wrong method", http.MethodPost, nil, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeSTSServer(t, tt.failures...)
+			cfg := loadTestConfig(t, "-role-arn", "arn:aws:iam::123456789012:role/test", "-cluster", "test",
+				"-serve", ":0", "-aws-endpoint", srv.URL, "-max-retries", "0")
+			auth := NewAuthenticator(cfg)
+			auth.metadataHTTPClient = md.client()
+			handler := credentialsHandler(cfg, auth, "session", execCredentialV1)
+
+			before := map[string]float64{}
+			for _, result := range []string{"success", "error"} {
+				before[result] = testutil.ToFloat64(stsCallsTotal.WithLabelValues("AssumeRoleWithWebIdentity", result))
+			}
+			requests := testutil.ToFloat64(credentialRequestsTotal.WithLabelValues(fmt.Sprint(tt.want)))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/credentials", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), `"kind":"ExecCredential"`) {
+				t.Errorf("body = %s, want ExecCredential", rec.Body)
+			}
+			if got := testutil.ToFloat64(credentialRequestsTotal.WithLabelValues(fmt.Sprint(tt.want))) - requests; got != 1 {
+				t.Errorf("credential requests with code %d = %v, want 1", tt.want, got)
+			}
+			for result, count := range before {
+				want := 0.0
+				if result == tt.result {
+					want = 1
+				}
+				if got := testutil.ToFloat64(stsCallsTotal.WithLabelValues("AssumeRoleWithWebIdentity", result)) - count; got != want {
+					t.Errorf("AssumeRoleWithWebIdentity calls with result %s = %v, want %v", result, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCredentialsHandlerAuthentication(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+	srv := newFakeSTSServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadTestConfig(t, "-role-arn", "arn:aws:iam::123456789012:role/test", "-cluster", "test",
+		"-serve", ":0", "-serve-token-file", tokenFile, "-aws-endpoint", srv.URL)
+	auth := NewAuthenticator(cfg)
+	auth.metadataHTTPClient = md.client()
+	handler := credentialsHandler(cfg, auth, "session", execCredentialV1)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"not a bearer token", "Basic s3cret", http.StatusUnauthorized},
+		{"valid token", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/credentials", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+	if got := len(srv.Requests()); got != 1 {
+		t.Errorf("STS requests = %d, want 1 for the authorized request only", got)
+	}
+
+	// A rotated token is picked up without restarting the server
+	if err := os.WriteFile(tokenFile, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/credentials", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status with the old token after rotation = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCredentialsHandlerMock(t *testing.T) {
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-serve", ":0", "-mock", "-expected-aws-account", "123456789012")
+	handler := credentialsHandler(cfg, newMockAuthenticator(NewAuthenticator(cfg)), "session", execCredentialV1)
+
+	calls := map[string]float64{}
+	for _, operation := range []string{"AssumeRoleWithWebIdentity", "GetCallerIdentity"} {
+		calls[operation] = testutil.ToFloat64(stsCallsTotal.WithLabelValues(operation, "success"))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/credentials", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"kind":"ExecCredential"`) {
+		t.Fatalf("status = %d, body = %s, want ExecCredential", rec.Code, rec.Body)
+	}
+	// Mock mode doesn't call STS, so no calls are counted
+	for operation, count := range calls {
+		if got := testutil.ToFloat64(stsCallsTotal.WithLabelValues(operation, "success")) - count; got != 0 {
+			t.Errorf("%s calls = %v, want 0 in mock mode", operation, got)
+		}
+	}
+}
+
+func TestCredentialsHandlerLabelsSTSCallsBySource(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("[profile ci]\naws_access_key_id = AKIAPROFILEKEY000000\naws_secret_access_key = profile-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	// Ambient credentials of the default AWS credential chain
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAAMBIENTKEY000000")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "ambient-secret")
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+
+	tests := []struct {
+		name      string
+		args      []string
+		operation string // Counted STS operation, empty when STS isn't called
+	}{
+		{"web identity", []string{"-role-arn", testRoleARN}, "AssumeRoleWithWebIdentity"},
+		{"ambient assuming role", []string{"-role-arn", testRoleARN, "-credential-source", "ambient"}, "AssumeRole"},
+		{"ambient", []string{"-credential-source", "ambient"}, ""},
+		{"profile", []string{"-role-arn", testRoleARN, "-credential-source", "profile:ci"}, "AssumeRole"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeSTSServer(t)
+			cfg := loadTestConfig(t, append(tt.args, "-cluster", "test", "-serve", ":0", "-aws-endpoint", srv.URL, "-max-retries", "0")...)
+			auth := NewAuthenticator(cfg)
+			auth.metadataHTTPClient = md.client()
+			handler := credentialsHandler(cfg, auth, "session", execCredentialV1)
+
+			before := map[string]float64{}
+			for _, operation := range []string{"AssumeRoleWithWebIdentity", "AssumeRole"} {
+				before[operation] = testutil.ToFloat64(stsCallsTotal.WithLabelValues(operation, "success"))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/credentials", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			for operation, count := range before {
+				want := 0.0
+				if operation == tt.operation {
+					want = 1
+				}
+				if got := testutil.ToFloat64(stsCallsTotal.WithLabelValues(operation, "success")) - count; got != want {
+					t.Errorf("%s calls = %v, want %v", operation, got, want)
+				}
+			}
+			var actions []string
+			for _, r := range srv.Requests() {
+				actions = append(actions, r.form.Get("Action"))
+			}
+			if tt.operation == "" && len(actions) != 0 || tt.operation != "" && !slices.Equal(actions, []string{tt.operation}) {
+				t.Errorf("STS actions = %q, want %q", actions, tt.operation)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Logs a finished outbound call with its duration at debug level. Status is the HTTP
+// status code of the response, 0 when no response was received.
+func logCall(operation string, attempt int, status int, start time.Time, err error) {
+	logger.Debug("External call finished", "operation", operation, "attempt", attempt, "status", status,
+		"duration_ms", time.Since(start).Milliseconds(), "success", err == nil && status > 0 && status < 400)
+}
+
+type callAttemptKey struct{}
+
+// Returns context carrying the attempt number (starting at 1) of the outbound call
+func withCallAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, callAttemptKey{}, attempt)
+}
+
+// Returns attempt number carried by ctx, 1 when unset
+func callAttempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(callAttemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// Transport logging every request to the GCP metadata server
+type timingTransport struct {
+	next http.RoundTripper
+}
+
+func (t timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	logCall("gcp.metadata "+req.URL.Path, callAttempt(req.Context()), status, start, err)
+	return resp, err
+}
+
+// Adds middleware logging every attempt of an AWS API call to the stack. It runs
+// after the retry middleware, so each attempt is logged separately.
+func addCallTiming(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("CallTiming", func(
+		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler,
+	) (middleware.DeserializeOutput, middleware.Metadata, error) {
+		attempt := 1
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			attempt = sdkRequestAttempt(req.Header.Get("Amz-Sdk-Request"))
+		}
+		start := time.Now()
+		out, metadata, err := next.HandleDeserialize(ctx, in)
+		status := 0
+		if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+			status = resp.StatusCode
+		}
+		logCall("aws."+strings.ToLower(awsmiddleware.GetServiceID(ctx))+" "+awsmiddleware.GetOperationName(ctx), attempt, status, start, err)
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// Returns attempt number from the amz-sdk-request header set by the SDK retryer, e.g. "attempt=2; max=3"
+func sdkRequestAttempt(header string) int {
+	for _, part := range strings.Split(header, ";") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(part), "attempt="); ok {
+			if attempt, err := strconv.Atoi(value); err == nil {
+				return attempt
+			}
+		}
+	}
+	return 1
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCallTimingLogFields(t *testing.T) {
+	logs := captureLogs(t)
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	md := newFakeMetadataServer(t, map[string]string{"instance/service-accounts/default/identity": "header.payload.signature"})
+	srv := newFakeSTSServer(t, stsFailure{http.StatusServiceUnavailable, "ServiceUnavailable"})
+	cfg := loadTestConfig(t, "-role-arn", testRoleARN, "-cluster", "test", "-aws-endpoint", srv.URL,
+		"-gcp-metadata-host", md.host(), "-max-retries", "1", "-retry-backoff", "1ms")
+	auth := NewAuthenticator(cfg)
+
+	if _, err := issueCredential(context.Background(), cfg, auth, "session", execCredentialV1); err != nil {
+		t.Fatalf("issueCredential() error = %v", err)
+	}
+
+	type call struct {
+		operation string
+		attempt   float64
+		status    float64
+	}
+	var calls []call
+	for _, entry := range logEntries(t, logs.String(), "External call finished") {
+		for _, field := range []string{"operation", "attempt", "status", "duration_ms", "success"} {
+			if _, ok := entry[field]; !ok {
+				t.Errorf("log entry %v misses field %s", entry, field)
+			}
+		}
+		operation, _ := entry["operation"].(string)
+		attempt, _ := entry["attempt"].(float64)
+		status, _ := entry["status"].(float64)
+		calls = append(calls, call{operation, attempt, status})
+	}
+	want := []call{
+		{"gcp.metadata /computeMetadata/v1/instance/service-accounts/default/identity", 1, http.StatusOK},
+		{"aws.sts AssumeRoleWithWebIdentity", 1, http.StatusServiceUnavailable},
+		{"aws.sts AssumeRoleWithWebIdentity", 2, http.StatusOK},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("logged calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("logged call %d = %v, want %v", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestSDKRequestAttempt(t *testing.T) {
+	tests := map[string]int{
+		"attempt=1; max=3": 1,
+		"attempt=2; max=3": 2,
+		"max=3; attempt=3": 3,
+		"":                 1,
+		"attempt=x":        1,
+	}
+	for header, want := range tests {
+		if got := sdkRequestAttempt(header); got != want {
+			t.Errorf("sdkRequestAttempt(%q) = %d, want %d", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Tokens expiring sooner than this are fetched again rather than reused
+const identityTokenMinValidity = 1 * time.Minute
+
+// Cache of GCP identity tokens keyed by audience and format, reusing tokens until
+// shortly before their exp claim. Tokens whose claims can't be decoded aren't cached.
+type identityTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedIdentityToken
+}
+
+type cachedIdentityToken struct {
+	token     customIdentityTokenRetriever
+	expiresAt time.Time
+}
+
+// Returns cache key of the token for given audience and format
+func identityTokenKey(audience, format string) string {
+	return audience + "|" + format
+}
+
+// Returns cached token that stays valid for at least identityTokenMinValidity
+func (c *identityTokenCache) get(key string) (customIdentityTokenRetriever, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.tokens[key]
+	if !ok || time.Until(cached.expiresAt) < identityTokenMinValidity {
+		return customIdentityTokenRetriever{}, false
+	}
+	return cached.token, true
+}
+
+// Stores token, expiring it at its exp claim
+func (c *identityTokenCache) put(key string, token customIdentityTokenRetriever) {
+	claims, err := decodeJWTClaims(token.token)
+	if err != nil || claims.Expiry == 0 {
+		logger.Debug("Not caching GCP identity token without readable expiration", "error", err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = map[string]cachedIdentityToken{}
+	}
+	c.tokens[key] = cachedIdentityToken{token: token, expiresAt: claims.ExpiresAt()}
+}
+
+// Drops cached token, e.g. after STS rejected it
+func (c *identityTokenCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, key)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+)
+
+var (
+	_ stscreds.IdentityTokenRetriever = FileTokenRetriever{}
+	_ stscreds.IdentityTokenRetriever = (*StdinTokenRetriever)(nil)
+)
+
+// Retrieves the identity token from a file, e.g. a projected service account token,
+// instead of GCP metadata. The file is re-read on each call to pick up rotated tokens.
+type FileTokenRetriever struct {
+	Path string
+}
+
+func (r FileTokenRetriever) GetIdentityToken() ([]byte, error) {
+	b, err := os.ReadFile(r.Path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	token := bytes.TrimSpace(b)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token file %s is empty", r.Path)
+	}
+	return token, nil
+}
+
+// Returns the identity token read from stdin once at startup, e.g. piped in by an
+// orchestration layer that already has a fresh token
+type StdinTokenRetriever struct {
+	token []byte
+}
+
+// Reads the identity token from r until EOF, so that nothing is written to stdout
+// before the input is consumed
+func NewStdinTokenRetriever(r io.Reader) (*StdinTokenRetriever, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+	token := bytes.TrimSpace(b)
+	if len(token) == 0 {
+		return nil, errors.New("no identity token on stdin")
+	}
+	return &StdinTokenRetriever{token: token}, nil
+}
+
+func (r *StdinTokenRetriever) GetIdentityToken() ([]byte, error) {
+	return r.token, nil
+}
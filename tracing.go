@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = programName
+
+// Sets up the global tracer provider exporting spans over OTLP/HTTP to endpoint.
+// Without an endpoint the default no-op tracer is kept. The returned function
+// flushes pending spans and must be called before exiting.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("otlptracehttp.New: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(tracerName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Sets up tracing with [setupTracing] and starts the root span of the run, returning ctx
+// carrying it. The returned function ends the root span and flushes pending spans, and
+// must be called before exiting.
+func startTracing(ctx context.Context, endpoint string) (context.Context, func(), error) {
+	shutdownTracing, err := setupTracing(ctx, endpoint)
+	if err != nil {
+		return ctx, nil, err
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "auth")
+	return ctx, func() {
+		span.End()
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Warn("Failed to flush traces", "error", err)
+		}
+	}, nil
+}
+
+// Runs fn within a span named after the phase of the auth flow, recording its error.
+// Duration of the phase is also logged at debug level.
+func withSpan(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+	defer span.End()
+	start := time.Now()
+	err := fn(ctx)
+	logger.Debug("Phase finished", "phase", name, "duration_ms", time.Since(start).Milliseconds(), "success", err == nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPhaseSpansNestInRootSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	logs := captureLogs(t)
+
+	ctx, finishTracing, err := startTracing(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = withSpan(ctx, "gcp.metadata", func(context.Context) error { return nil })
+	finishTracing()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	root, phase := spans["auth"], spans["gcp.metadata"]
+	if root == nil || phase == nil {
+		t.Fatalf("ended spans = %v, want auth and gcp.metadata", spans)
+	}
+	if root.Parent().IsValid() {
+		t.Errorf("auth span has parent %v, want root span", root.Parent().SpanID())
+	}
+	if phase.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("gcp.metadata parent = %v, want auth span %v", phase.Parent().SpanID(), root.SpanContext().SpanID())
+	}
+
+	entries := logEntries(t, logs.String(), "Phase finished")
+	if len(entries) != 1 || entries[0]["phase"] != "gcp.metadata" || entries[0]["duration_ms"] == nil || entries[0]["success"] != true {
+		t.Errorf("phase log entries = %v, want one gcp.metadata entry with its duration", entries)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "runtime/debug"
+
+const programName = "argocd-k8s-auth-gke-wli-eks"
+
+// Version of the build, set with -ldflags "-X main.version=<version>"
+var version string
+
+// Returns version of the build, falling back to the module version from the build info
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}